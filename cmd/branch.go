@@ -1,69 +1,581 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 
+	"github.com/fatih/color"
+	"github.com/lithammer/dedent"
 	"github.com/spf13/cobra"
+	"go.olrik.dev/pila/internal/git"
 )
 
+func NewBranchCommand() *cobra.Command {
+	branchCmd := &cobra.Command{
+		Use:     "branch",
+		Aliases: []string{"br"},
+		Short:   "Work with stacks of branches",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Work with stacks of branches
+
+			A stack is a chain of branches, each branching off of the one below it,
+			with the main branch at the bottom.
+		`)),
+	}
+
+	branchCmd.AddCommand(NewBranchListCommand())
+	branchCmd.AddCommand(NewBranchUpCommand())
+	branchCmd.AddCommand(NewBranchDownCommand())
+	branchCmd.AddCommand(NewBranchTopCommand())
+	branchCmd.AddCommand(NewBranchBottomCommand())
+	branchCmd.AddCommand(NewBranchCreateCommand())
+	branchCmd.AddCommand(NewBranchTrackCommand())
+	branchCmd.AddCommand(NewBranchUntrackCommand())
+	branchCmd.AddCommand(NewBranchRestackCommand())
+	branchCmd.AddCommand(NewBranchSyncCommand())
+	branchCmd.AddCommand(NewBranchPublishCommand())
+	branchCmd.AddCommand(NewBranchProposeCommand())
+
+	return branchCmd
+}
+
+func printStackGraph(graph *git.StackGraph, checkedOutBranchName string) {
+	greenText := color.New(color.FgGreen).SprintFunc()
+	boldText := color.New(color.FgHiWhite, color.Bold).SprintFunc()
+	graph.Walk(func(node *git.StackNode, depth int) {
+		branchName := node.Branch
+		hereIndicator := ""
+		if branchName == checkedOutBranchName {
+			hereIndicator = boldText(" *")
+			branchName = greenText(branchName)
+		}
+
+		commits := ""
+		if node.Parent != nil {
+			commits = color.HiBlackString(" (%d)", node.Commits)
+		}
+		fmt.Printf("%s%s%s%s\n", strings.Repeat("  ", depth), branchName, commits, hereIndicator)
+	})
+}
+
 func NewBranchListCommand() *cobra.Command {
 	listCmd := &cobra.Command{
 		Use:     "list",
 		Aliases: []string{"ls"},
 		Short:   "Show branch stack",
-		Long:    "Show branch stack",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Show the stack the checked out branch is part of as a tree,
+			with the number of commits on each branch.
+			Use --all to show every stack rooted at the main branch.
+		`)),
 		Run: func(cmd *cobra.Command, args []string) {
-			// mainBranchName, err := git.MainBranchName()
-			// if err != nil {
-			// 	return
-			// }
-
-			// checkedOutBranchName, err := git.CheckedOutBranchName()
-			// if err != nil {
-			// 	return
-			// }
-
-			// branchStack, err := git.GetBranchStack(mainBranchName, checkedOutBranchName)
-			// if err != nil {
-			// 	fmt.Println(err)
-			// 	return
-			// }
-
-			// greenText := color.New(color.FgGreen).SprintFunc()
-			// boldText := color.New(color.FgHiWhite, color.Bold).SprintFunc()
-			// for i, branchName := range branchStack {
-			// 	hereIndicator := "  "
-			// 	if branchName == checkedOutBranchName {
-			// 		hereIndicator = boldText(" *")
-			// 		branchName = greenText(branchName)
-			// 	}
-			// 	fmt.Printf("%s%s%s\n", strings.Repeat("  ", i), branchName, hereIndicator)
-			// }
+			all, _ := cmd.Flags().GetBool("all")
+
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			mainBranchName, err := repo.MainBranchName()
+			cobra.CheckErr(err)
+
+			checkedOutBranchName, err := repo.CheckedOutBranchName()
+			cobra.CheckErr(err)
+
+			graph, err := repo.BuildStackGraph(mainBranchName)
+			cobra.CheckErr(err)
+
+			if !all {
+				graph, err = graph.Stack(checkedOutBranchName)
+				cobra.CheckErr(err)
+			}
+
+			printStackGraph(graph, checkedOutBranchName)
 		},
 	}
+	listCmd.Flags().BoolP("all", "a", false, "Show all stacks rooted at the main branch")
+
 	return listCmd
 }
 
+func localBranchCompletions(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	repo, err := git.GetLocalRepository()
+	if err != nil {
+		panic(err)
+	}
+
+	suggestions := []string{}
+	heads, err := repo.Heads()
+	if err != nil {
+		return suggestions, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	for branchName := range heads {
+		if strings.HasPrefix(branchName, toComplete) {
+			suggestions = append(suggestions, branchName)
+		}
+	}
+
+	return suggestions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// checkoutInStack checks out the branch pick finds in the stack of the checked out branch
+func checkoutInStack(cmd *cobra.Command, args []string, pick func(stack *git.StackGraph, branchName string, index int) (string, error)) {
+	// Get handle on local repo
+	repo, err := git.GetLocalRepository()
+	if err != nil {
+		panic(err)
+	}
+
+	index := 0
+	if len(args) > 0 {
+		index, err = strconv.Atoi(args[0])
+		if err != nil || index < 1 {
+			cobra.CheckErr(fmt.Errorf("index must be a positive number, got '%s'", args[0]))
+		}
+	}
+
+	mainBranchName, err := repo.MainBranchName()
+	cobra.CheckErr(err)
+
+	checkedOutBranchName, err := repo.CheckedOutBranchName()
+	cobra.CheckErr(err)
+
+	stack, err := repo.GetBranchStack(mainBranchName, checkedOutBranchName)
+	cobra.CheckErr(err)
+
+	branchName, err := pick(stack, checkedOutBranchName, index)
+	var ambiguousErr *git.AmbiguousChildrenError
+	if errors.As(err, &ambiguousErr) {
+		fmt.Printf("Several branches are stacked on top of %s:\n\n", color.CyanString(ambiguousErr.BranchName))
+		for i, child := range ambiguousErr.Children {
+			fmt.Printf("  %d  %s\n", i+1, child)
+		}
+		fmt.Println()
+		fmt.Println("Pick one by adding its index to the command, e.g. " + color.GreenString("%s 1", cmd.CommandPath()))
+		os.Exit(1)
+	}
+	cobra.CheckErr(err)
+
+	err = repo.CheckoutBranch(branchName)
+	cobra.CheckErr(err)
+}
+
+func NewBranchUpCommand() *cobra.Command {
+	upCmd := &cobra.Command{
+		Use:     "up [index]",
+		Aliases: []string{"u"},
+		Short:   "Checkout the branch stacked on top of the current one",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Checkout the branch stacked on top of the checked out branch.
+
+			When several branches are stacked on top of it, they are listed,
+			and the index picks which one to checkout.
+		`)),
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			checkoutInStack(cmd, args, func(stack *git.StackGraph, branchName string, index int) (string, error) {
+				return stack.Child(branchName, index)
+			})
+		},
+	}
+	return upCmd
+}
+
+func NewBranchDownCommand() *cobra.Command {
+	downCmd := &cobra.Command{
+		Use:     "down",
+		Aliases: []string{"d"},
+		Short:   "Checkout the parent of the current branch",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkoutInStack(cmd, args, func(stack *git.StackGraph, branchName string, index int) (string, error) {
+				return stack.ParentOf(branchName)
+			})
+		},
+	}
+	return downCmd
+}
+
+func NewBranchTopCommand() *cobra.Command {
+	topCmd := &cobra.Command{
+		Use:     "top [index]",
+		Aliases: []string{"t"},
+		Short:   "Checkout the branch at the top of the stack",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Checkout the branch at the top of the stack of the checked out branch.
+
+			When the stack forks, the branches at the fork are listed,
+			and the index picks which way to go.
+		`)),
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			checkoutInStack(cmd, args, func(stack *git.StackGraph, branchName string, index int) (string, error) {
+				return stack.Top(branchName, index)
+			})
+		},
+	}
+	return topCmd
+}
+
+func NewBranchBottomCommand() *cobra.Command {
+	bottomCmd := &cobra.Command{
+		Use:     "bottom",
+		Aliases: []string{"b"},
+		Short:   "Checkout the branch at the bottom of the stack",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkoutInStack(cmd, args, func(stack *git.StackGraph, branchName string, index int) (string, error) {
+				return stack.Bottom(branchName)
+			})
+		},
+	}
+	return bottomCmd
+}
+
+func NewBranchCreateCommand() *cobra.Command {
+	createCmd := &cobra.Command{
+		Use:     "create <name>",
+		Aliases: []string{"new"},
+		Short:   "Create a branch on top of the checked out branch",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Create a new branch off of the checked out branch and check it out.
+			The checked out branch is recorded as the parent of the new branch.
+		`)),
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			err = repo.CreateStackBranch(args[0])
+			cobra.CheckErr(err)
+		},
+	}
+	return createCmd
+}
+
+func NewBranchTrackCommand() *cobra.Command {
+	trackCmd := &cobra.Command{
+		Use:   "track [branch]",
+		Short: "Record the parent of an existing branch",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Adopt an existing branch into a stack by recording its parent.
+			Defaults to the checked out branch, and to the parent found through commit ancestry.
+		`)),
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: localBranchCompletions,
+		Run: func(cmd *cobra.Command, args []string) {
+			parentName, _ := cmd.Flags().GetString("parent")
+
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			branchName := ""
+			if len(args) > 0 {
+				branchName = args[0]
+			} else {
+				branchName, err = repo.CheckedOutBranchName()
+				cobra.CheckErr(err)
+			}
+
+			if parentName == "" {
+				mainBranchName, err := repo.MainBranchName()
+				cobra.CheckErr(err)
+
+				graph, err := repo.BuildStackGraph(mainBranchName)
+				cobra.CheckErr(err)
+
+				node, ok := graph.Nodes[branchName]
+				if !ok || node.Parent == nil {
+					cobra.CheckErr(fmt.Errorf("unable to find a parent for %s, please use --parent", branchName))
+				}
+				parentName = node.Parent.Branch
+			}
+
+			err = repo.TrackStackBranch(branchName, parentName)
+			cobra.CheckErr(err)
+
+			fmt.Printf("%s is now stacked on %s\n", color.CyanString(branchName), color.CyanString(parentName))
+		},
+	}
+	trackCmd.Flags().StringP("parent", "p", "", "Parent branch")
+	trackCmd.RegisterFlagCompletionFunc("parent", localBranchCompletions)
+
+	return trackCmd
+}
+
+func NewBranchUntrackCommand() *cobra.Command {
+	untrackCmd := &cobra.Command{
+		Use:   "untrack [branch]",
+		Short: "Forget the recorded parent of a branch",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Forget the recorded parent of a branch, defaults to the checked out branch.
+			The branch is then placed in stacks using commit ancestry.
+		`)),
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: localBranchCompletions,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			branchName := ""
+			if len(args) > 0 {
+				branchName = args[0]
+			} else {
+				branchName, err = repo.CheckedOutBranchName()
+				cobra.CheckErr(err)
+			}
+
+			err = repo.UnsetStackParent(branchName)
+			cobra.CheckErr(err)
+		},
+	}
+	return untrackCmd
+}
+
+func handleRestackError(err error) {
+	if err == nil {
+		return
+	}
+
+	var conflictErr *git.RestackConflictError
+	if errors.As(err, &conflictErr) {
+		fmt.Println()
+		fmt.Println(color.RedString("Rebase conflict detected!"))
+		fmt.Println()
+		fmt.Printf("A conflict occurred while rebasing %s onto %s\n", color.CyanString(conflictErr.BranchName), color.CyanString(conflictErr.ParentName))
+		fmt.Println()
+		fmt.Println("To resolve:")
+		fmt.Println("  1. Fix the conflicts in your working directory")
+		fmt.Println("  2. Stage the resolved files with " + color.GreenString("git add <files>"))
+		fmt.Println("  3. Continue the restack with " + color.GreenString("pila branch restack continue"))
+		fmt.Println()
+		fmt.Println("Or abort the restack with " + color.YellowString("pila branch restack abort"))
+		fmt.Println()
+	}
+}
+
+func NewBranchRestackCommand() *cobra.Command {
+	restackCmd := &cobra.Command{
+		Use:   "restack",
+		Short: "Rebase every branch in the stack onto its parent",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Rebase every branch in the stack of the checked out branch onto its parent,
+			starting from the bottom. Use this after amending or rebasing a branch with
+			other branches stacked on top of it.
+		`)),
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			err = repo.Restack()
+			handleRestackError(err)
+			cobra.CheckErr(err)
+		},
+	}
+
+	restackCmd.AddCommand(&cobra.Command{
+		Use:     "continue",
+		Aliases: []string{"cont"},
+		Short:   "Continue ongoing restack after resolving conflicts",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			err = repo.RestackContinue()
+			handleRestackError(err)
+			cobra.CheckErr(err)
+		},
+	})
+
+	restackCmd.AddCommand(&cobra.Command{
+		Use:   "abort",
+		Short: "Abort ongoing restack and reset all branches",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			err = repo.RestackAbort()
+			cobra.CheckErr(err)
+		},
+	})
+
+	return restackCmd
+}
+
+func NewBranchSyncCommand() *cobra.Command {
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Remove merged branches from stacks",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Fetch the base remote and find stack branches that have been merged into the main branch,
+			including branches that were squash merged. Each merged branch can be deleted locally,
+			and the branches stacked on top of it are rebased onto the main branch.
+
+			Conflicts are handled like in restack, using 'pila branch restack continue|abort'.
+		`)),
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			yes, _ := cmd.Flags().GetBool("yes")
+			stdin := bufio.NewReader(os.Stdin)
+			err = repo.SyncStacks(func(branchName string) bool {
+				if yes {
+					return true
+				}
+
+				fmt.Printf("\nDelete merged branch %s? [y/N] ", color.CyanString(branchName))
+				answer, _ := stdin.ReadString('\n')
+				answer = strings.ToLower(strings.TrimSpace(answer))
+				return answer == "y" || answer == "yes"
+			})
+			handleRestackError(err)
+			cobra.CheckErr(err)
+		},
+	}
+	syncCmd.Flags().BoolP("yes", "y", false, "Delete merged branches without asking")
+
+	return syncCmd
+}
+
 func NewBranchProposeCommand() *cobra.Command {
-	proproseCmd := &cobra.Command{
+	proposeCmd := &cobra.Command{
 		Use:   "propose",
-		Short: "Publish & propose stack",
-		Long:  "Publish & propose stack",
+		Short: "Publish stack and open a merge request per branch",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Publish the stack of the checked out branch, then create or update a merge request
+			for every branch in it, targeting its parent branch.
+
+			Every merge request description gets a table linking the other merge requests
+			in the stack. Running propose again updates the existing merge requests.
+		`)),
+		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("Publish & propose stack")
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			publishResults, err := repo.PublishStack()
+			cobra.CheckErr(err)
+
+			if rejected := printPublishResults(publishResults); rejected > 0 {
+				fmt.Println()
+				fmt.Println("Someone else pushed to the rejected branches, fetch and restack before proposing again")
+				cobra.CheckErr(fmt.Errorf("%d branch(es) rejected", rejected))
+			}
+
+			results, err := repo.ProposeStack()
+			cobra.CheckErr(err)
+
+			width := 0
+			for _, result := range results {
+				width = max(width, len(result.BranchName))
+			}
+
+			fmt.Println()
+			for _, result := range results {
+				status := color.HiBlackString(result.Status)
+				if result.Status != git.PUBLISH_STATUS_UP_TO_DATE {
+					status = color.GreenString(result.Status)
+				}
+				fmt.Printf("%s  #%d %s  %s\n", color.CyanString("%-*s", width, result.BranchName), result.MergeRequest.Number, result.MergeRequest.URL, status)
+			}
 		},
 	}
-	return proproseCmd
+	return proposeCmd
 }
 
 func NewBranchPublishCommand() *cobra.Command {
 	publishCmd := &cobra.Command{
 		Use:   "publish",
-		Short: "Publish stack",
-		Long:  "Publish stack",
+		Short: "Push every branch in the stack",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Push every branch in the stack of the checked out branch to the features remote,
+			origin unless configured otherwise, and set their upstreams.
+
+			Branches are force pushed with a lease on the last fetched remote sha, so a branch
+			is rejected instead of overwritten if someone else pushed to it in the meantime.
+		`)),
+		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("Publish stack")
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			results, err := repo.PublishStack()
+			cobra.CheckErr(err)
+
+			rejected := printPublishResults(results)
+			if rejected > 0 {
+				fmt.Println()
+				fmt.Println("Someone else pushed to the rejected branches, fetch and restack before publishing again")
+				cobra.CheckErr(fmt.Errorf("%d branch(es) rejected", rejected))
+			}
 		},
 	}
 	return publishCmd
 }
+
+// printPublishResults prints the outcome of every pushed branch, and returns the number of rejected branches
+func printPublishResults(results []git.PublishResult) int {
+	width := 0
+	for _, result := range results {
+		width = max(width, len(result.BranchName))
+	}
+
+	rejected := 0
+	fmt.Println()
+	for _, result := range results {
+		status := result.Status
+		switch result.Status {
+		case git.PUBLISH_STATUS_CREATED, git.PUBLISH_STATUS_UPDATED:
+			status = color.GreenString(status)
+		case git.PUBLISH_STATUS_UP_TO_DATE:
+			status = color.HiBlackString(status)
+		case git.PUBLISH_STATUS_REJECTED:
+			rejected++
+			status = color.RedString(status)
+			if result.Reason != "" {
+				status += color.HiBlackString(" (%s)", result.Reason)
+			}
+		}
+		fmt.Printf("%s  %s\n", color.CyanString("%-*s", width, result.BranchName), status)
+	}
+
+	return rejected
+}
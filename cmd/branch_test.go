@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+// newTestStacks creates a repository with the stack main <- a <- b, with b checked out, and a
+// separate stack main <- c, and changes into it for the duration of the test
+func newTestStacks(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(dir, ".gitconfig-global"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	gitCommand(t, "init", "-q", "-b", "main")
+	gitCommand(t, "config", "user.name", "Pila Test")
+	gitCommand(t, "config", "user.email", "pila@example.com")
+	gitCommand(t, "commit", "-q", "--allow-empty", "-m", "main")
+	gitCommand(t, "update-ref", "refs/remotes/origin/main", "main")
+	gitCommand(t, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/main")
+
+	gitCommand(t, "checkout", "-q", "-b", "c")
+	gitCommand(t, "commit", "-q", "--allow-empty", "-m", "c")
+	gitCommand(t, "checkout", "-q", "-b", "a", "main")
+	gitCommand(t, "commit", "-q", "--allow-empty", "-m", "a1")
+	gitCommand(t, "commit", "-q", "--allow-empty", "-m", "a2")
+	gitCommand(t, "checkout", "-q", "-b", "b")
+	gitCommand(t, "commit", "-q", "--allow-empty", "-m", "b")
+}
+
+// gitCommand runs git in the current directory and fails the test on errors
+func gitCommand(t *testing.T, arg ...string) {
+	t.Helper()
+
+	if output, err := exec.Command("git", arg...).CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(arg, " "), err, output)
+	}
+}
+
+// runBranchList runs pila branch list with the given arguments and returns what it printed
+func runBranchList(t *testing.T, arg ...string) string {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	listCmd := NewBranchListCommand()
+	listCmd.SetArgs(arg)
+	err = listCmd.Execute()
+	writer.Close()
+	if err != nil {
+		t.Fatalf("branch list error = %v", err)
+	}
+
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
+
+func TestBranchList(t *testing.T) {
+	newTestStacks(t)
+
+	got := runBranchList(t)
+	if want := "main\n  a (2)\n    b (1) *\n"; got != want {
+		t.Errorf("branch list =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBranchList_All(t *testing.T) {
+	newTestStacks(t)
+
+	got := runBranchList(t, "--all")
+	if want := "main\n  a (2)\n    b (1) *\n  c (1)\n"; got != want {
+		t.Errorf("branch list --all =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBranchList_HighlightsCheckedOutBranch(t *testing.T) {
+	newTestStacks(t)
+
+	previousNoColor := color.NoColor
+	t.Cleanup(func() { color.NoColor = previousNoColor })
+	color.NoColor = false
+
+	lines := strings.Split(strings.TrimSpace(runBranchList(t)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("branch list printed %d lines, want 3:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+
+	highlighted := color.New(color.FgGreen).Sprint("b")
+	if !strings.HasPrefix(lines[2], "    "+highlighted) || !strings.Contains(lines[2], " *") {
+		t.Errorf("checked out branch line = %q, want b in green and marked with *", lines[2])
+	}
+	for _, line := range lines[:2] {
+		if strings.Contains(line, " *") || strings.Contains(line, "\x1b[32m") {
+			t.Errorf("line %q is highlighted, only the checked out branch should be", line)
+		}
+	}
+}
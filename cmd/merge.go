@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
 	"github.com/lithammer/dedent"
 	"github.com/spf13/cobra"
 	"go.olrik.dev/pila/internal/git"
@@ -27,7 +30,11 @@ func handleMultiMergeError(err error) {
 		fmt.Printf("A merge conflict occurred while merging branch %s\n", color.CyanString(conflictErr.BranchName))
 		fmt.Println()
 		fmt.Println("To resolve:")
-		fmt.Println("  1. Fix the conflicts in your working directory")
+		if conflictErr.WorkDir != "" {
+			fmt.Println("  1. Fix the conflicts in the multi-merge worktree " + color.CyanString(conflictErr.WorkDir))
+		} else {
+			fmt.Println("  1. Fix the conflicts in your working directory")
+		}
 		fmt.Println("  2. Stage the resolved files with " + color.GreenString("git add <files>"))
 		fmt.Println("  3. Continue the multi-merge with " + color.GreenString("pila multi-merge continue"))
 		fmt.Println()
@@ -35,21 +42,61 @@ func handleMultiMergeError(err error) {
 		fmt.Println()
 	}
 
+	// Check if this is a failed verify command
+	var verifyErr *git.MultiMergeVerifyError
+	if errors.As(err, &verifyErr) {
+		fmt.Println()
+		fmt.Println(color.RedString("Verification failed!"))
+		fmt.Println()
+		fmt.Printf("%s failed after merging branch %s\n", color.CyanString(verifyErr.Command), color.CyanString(verifyErr.BranchName))
+		fmt.Println()
+		fmt.Println("To resolve:")
+		if verifyErr.WorkDir != "" {
+			fmt.Println("  1. Fix the target branch in the multi-merge worktree " + color.CyanString(verifyErr.WorkDir))
+		} else {
+			fmt.Println("  1. Fix the target branch in your working directory")
+		}
+		fmt.Println("  2. Commit the fix with " + color.GreenString("git commit"))
+		fmt.Println("  3. Verify again and continue the multi-merge with " + color.GreenString("pila multi-merge continue"))
+		fmt.Println()
+		fmt.Println("Or abort the multi-merge with " + color.YellowString("pila multi-merge abort"))
+		fmt.Println()
+	}
+
+	// Check if someone else pushed to the target branch
+	var foreignErr *git.ForeignCommitsError
+	if errors.As(err, &foreignErr) {
+		fmt.Println()
+		fmt.Println(color.RedString("Refusing to push!"))
+		fmt.Println()
+		fmt.Printf("%s on %s has commits that were not pushed by pila:\n", color.CyanString(foreignErr.BranchName), color.CyanString(foreignErr.Remote))
+		for _, commit := range foreignErr.Commits {
+			fmt.Printf("  %s\n", commit)
+		}
+		fmt.Println()
+		fmt.Println("To resolve, either:")
+		fmt.Println("  1. Move the commits to one of the branches in the manifest, and run " + color.GreenString("pila multi-merge redo --push"))
+		fmt.Println()
+		fmt.Println("  2. Overwrite them:")
+		fmt.Printf("     %s\n", color.YellowString("git push --force-with-lease=%s:%s %s %s", foreignErr.BranchName, foreignErr.RemoteSha, foreignErr.Remote, foreignErr.BranchName))
+		fmt.Println()
+	}
+
 	// Check if this is a local-only branches error
 	var localOnlyErr *git.LocalOnlyBranchesError
 	if errors.As(err, &localOnlyErr) {
 		fmt.Println()
 		fmt.Println(color.RedString("Cannot redo: some branches only exist locally"))
 		fmt.Println()
-		fmt.Println("The following branches have no remote tracking branch:")
+		fmt.Printf("The following branches have no remote tracking branch on %s:\n", color.CyanString(localOnlyErr.Remote))
 		for _, branchName := range localOnlyErr.BranchNames {
 			fmt.Printf("  - %s\n", color.CyanString(branchName))
 		}
 		fmt.Println()
 		fmt.Println("To resolve, either:")
-		fmt.Println("  1. Push the branch(es) to remote:")
+		fmt.Printf("  1. Push the branch(es) to %s:\n", localOnlyErr.Remote)
 		for _, branchName := range localOnlyErr.BranchNames {
-			fmt.Printf("     %s\n", color.GreenString("git push -u origin %s", branchName))
+			fmt.Printf("     %s\n", color.GreenString("git push -u %s %s", localOnlyErr.Remote, branchName))
 		}
 		fmt.Println()
 		fmt.Println("  2. Remove the branch(es) from the manifest:")
@@ -60,13 +107,100 @@ func handleMultiMergeError(err error) {
 	}
 }
 
+// useMultiMergeWorkDir makes git commands run where the multi-merge is done, see LocalRepository.MultiMergeWorkDir
+func useMultiMergeWorkDir(repo *git.LocalRepository) error {
+	workDir, err := repo.MultiMergeWorkDir()
+	if err != nil {
+		return err
+	}
+	repo.WorkDir = workDir
+
+	return nil
+}
+
 func checkOngoingMerge(repo *git.LocalRepository) error {
-	if branchName, err := repo.OngoingMergeBranchName(); err == nil && branchName != "" {
+	// Look in the multi-merge worktree, if the manifest is there
+	workDir, _ := repo.MultiMergeWorkDir()
+	repo = &git.LocalRepository{Type: repo.Type, Repository: repo.Repository, WorkDir: workDir}
+
+	if branchName, err := repo.OngoingMergeBranchName(); (err == nil && branchName != "") || repo.SquashInProgress() {
 		return fmt.Errorf("a merge is currently in progress, please run 'pila multi-merge continue' or 'pila multi-merge abort' first")
 	}
 	return nil
 }
 
+// addReferenceOptionFlags adds the flags setting how new branches in the manifest are merged
+func addReferenceOptionFlags(cmd *cobra.Command) {
+	cmd.Flags().String("strategy", "", strings.TrimSpace(dedent.Dedent(`
+		Merge strategy for the new branches: ort, recursive or octopus
+		Consecutive octopus branches are merged together in a single merge
+	`)))
+	cmd.RegisterFlagCompletionFunc("strategy", cobra.FixedCompletions(
+		[]string{git.MULTI_MERGE_STRATEGY_ORT, git.MULTI_MERGE_STRATEGY_RECURSIVE, git.MULTI_MERGE_STRATEGY_OCTOPUS},
+		cobra.ShellCompDirectiveNoFileComp,
+	))
+	cmd.Flags().StringSliceP("strategy-option", "X", []string{}, "Strategy option for the new branches, e.g. ours, theirs or patience")
+	cmd.Flags().Bool("squash", false, "Squash each of the new branches into a single commit")
+	cmd.Flags().Bool("no-ff", false, "Always create a merge commit for the new branches")
+	cmd.Flags().Bool("allow-unrelated-histories", false, "Allow the new branches to have no common history with the target")
+}
+
+// referenceOptionsFromFlags reads the flags added by addReferenceOptionFlags
+func referenceOptionsFromFlags(cmd *cobra.Command) (git.MultiMergeReferenceOptions, error) {
+	options := git.MultiMergeReferenceOptions{}
+	options.Strategy, _ = cmd.Flags().GetString("strategy")
+	options.StrategyOptions, _ = cmd.Flags().GetStringSlice("strategy-option")
+	options.Squash, _ = cmd.Flags().GetBool("squash")
+	options.NoFastForward, _ = cmd.Flags().GetBool("no-ff")
+	options.AllowUnrelatedHistories, _ = cmd.Flags().GetBool("allow-unrelated-histories")
+
+	return options, options.Validate()
+}
+
+// addPushFlag adds the flag pushing the target branch once all branches are merged
+func addPushFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("push", false, strings.TrimSpace(dedent.Dedent(`
+		Push the target branch once all branches are merged
+		Refuses to overwrite commits on the remote branch that were not pushed by pila
+	`)))
+}
+
+// pushTarget pushes the target branch when the command was given --push
+func pushTarget(cmd *cobra.Command, repo *git.LocalRepository) {
+	if push, _ := cmd.Flags().GetBool("push"); !push {
+		return
+	}
+
+	err := repo.MultiMergePush()
+	handleMultiMergeError(err)
+	cobra.CheckErr(err)
+}
+
+// newReferences turns branch names into references merged with the given options
+func newReferences(branchNames []string, options git.MultiMergeReferenceOptions) []git.MultiMergeReference {
+	references := []git.MultiMergeReference{}
+	for _, branchName := range branchNames {
+		references = append(references, git.MultiMergeReference{Name: branchName, Options: options})
+	}
+
+	return references
+}
+
+// unmergedReferences returns copies of the references, ready to be merged again from scratch
+func unmergedReferences(references []git.MultiMergeReference) []git.MultiMergeReference {
+	unmerged := []git.MultiMergeReference{}
+	for _, reference := range references {
+		reference.Merged = false
+		reference.Skipped = false
+		reference.ConflictingFiles = nil
+		reference.VerifyFailed = false
+		reference.Sha = ""
+		unmerged = append(unmerged, reference)
+	}
+
+	return unmerged
+}
+
 // filterDuplicateBranches returns branches from newBranches that are not already
 // in existingBranches. Also returns the list of duplicates found.
 func filterDuplicateBranches(existingBranches, newBranches []string) (filtered, duplicates []string) {
@@ -133,12 +267,31 @@ func NewMultiMergeCommand() *cobra.Command {
 			err = checkOngoingMerge(repo)
 			cobra.CheckErr(err)
 
-			// Load previous manifest if needed
-			manifest, _ := git.LoadMultiMergeManifest()
+			// Load previous manifest if needed, from the multi-merge worktree when it is not here
+			workDir, _ := repo.MultiMergeWorkDir()
+			manifest, _ := git.LoadMultiMergeManifest(workDir)
 			if target == "" && manifest != nil {
 				target = manifest.Target
 			}
 
+			// Merging into the same target again keeps its settings, unless overridden by flags
+			options := git.DefaultMultiMergeOptions()
+			if manifest != nil && manifest.Target == target {
+				options = manifest.Options
+			}
+			if cmd.Flags().Changed("worktree") {
+				options.Worktree, _ = cmd.Flags().GetBool("worktree")
+			}
+			if cmd.Flags().Changed("base") {
+				options.Base, _ = cmd.Flags().GetString("base")
+			}
+			if cmd.Flags().Changed("on-conflict") {
+				options.OnConflict, _ = cmd.Flags().GetString("on-conflict")
+			}
+			if cmd.Flags().Changed("exec") {
+				options.Verify, _ = cmd.Flags().GetString("exec")
+			}
+
 			// All multi merges require a target branch
 			if (len(branches) > 0 || len(labels) > 0) && target == "" {
 				err := errors.New("target is required when specifying branches or labels")
@@ -146,22 +299,51 @@ func NewMultiMergeCommand() *cobra.Command {
 			}
 
 			if len(branches) > 0 {
-				_, err := repo.MultiMergeNamedBranches(target, branches)
+				_, err := repo.MultiMergeNamedBranches(target, branches, options)
 				handleMultiMergeError(err)
 				cobra.CheckErr(err)
 			} else if len(labels) > 0 {
-				err := repo.MultiMergeNamedLabels(target, labels)
+				_, err := repo.MultiMergeNamedLabels(target, labels, options)
+				handleMultiMergeError(err)
 				cobra.CheckErr(err)
 			}
+
+			pushTarget(cmd, repo)
 		},
 	}
 	multiMergeCmd.Flags().StringP("target", "T", "", strings.TrimSpace(dedent.Dedent(`
 			Target branch
-			⚠️ the target branch will be deleted and recreated locally as a clean branch off of the main branch
+			⚠️ the target branch will be deleted and recreated locally as a clean branch off of the main or --base branch
 		`)),
 	)
 	multiMergeCmd.RegisterFlagCompletionFunc("target", branchNameCompletions)
 	multiMergeCmd.Flags().BoolP("force", "F", false, "Don't ask before deleting target branch")
+	multiMergeCmd.Flags().String("base", "", strings.TrimSpace(dedent.Dedent(`
+			Branch on the base remote to build the target branch on, e.g. release/2.x
+			Defaults to the main branch, or the base of the previous multi-merge into the same target
+		`)),
+	)
+	multiMergeCmd.RegisterFlagCompletionFunc("base", branchNameCompletions)
+	multiMergeCmd.Flags().String("on-conflict", "", strings.TrimSpace(dedent.Dedent(`
+			What to do when a branch conflicts: stop to resolve the conflict, or skip the branch and merge the rest
+			Defaults to the multi_merge.on_conflict config setting, or stop
+		`)),
+	)
+	multiMergeCmd.RegisterFlagCompletionFunc("on-conflict", cobra.FixedCompletions(
+		[]string{git.MULTI_MERGE_ON_CONFLICT_STOP, git.MULTI_MERGE_ON_CONFLICT_SKIP},
+		cobra.ShellCompDirectiveNoFileComp,
+	))
+	multiMergeCmd.Flags().String("exec", "", strings.TrimSpace(dedent.Dedent(`
+			Command to run after every merge, e.g. "go build ./...", stopping the multi-merge when it fails
+			Defaults to the multi_merge.verify config setting, use --exec "" to turn it off
+		`)),
+	)
+	addPushFlag(multiMergeCmd)
+	multiMergeCmd.Flags().Bool("worktree", false, strings.TrimSpace(dedent.Dedent(`
+			Merge in a dedicated worktree in .git/pila/worktrees/<target>, leaving the current checkout alone
+			Defaults to the multi_merge.worktree config setting
+		`)),
+	)
 
 	multiMergeCmd.Flags().StringSliceP("branch", "B", []string{}, strings.TrimSpace(dedent.Dedent(`
 			Branches to merge into target branch
@@ -183,11 +365,15 @@ func NewMultiMergeCommand() *cobra.Command {
 	multiMergeCmd.AddCommand(NewMultiMergeAbortCommand())
 	multiMergeCmd.AddCommand(NewMultiMergeContinueCommand())
 	multiMergeCmd.AddCommand(NewMultiMergeShowCommand())
+	multiMergeCmd.AddCommand(NewMultiMergeStatusCommand())
 	multiMergeCmd.AddCommand(NewMultiMergeRedoCommand())
 	multiMergeCmd.AddCommand(NewMultiMergeAppendCommand())
 	multiMergeCmd.AddCommand(NewMultiMergePrependCommand())
 	multiMergeCmd.AddCommand(NewMultiMergeRemoveCommand())
 	multiMergeCmd.AddCommand(NewMultiMergeTestCommand())
+	multiMergeCmd.AddCommand(NewMultiMergeReorderCommand())
+	multiMergeCmd.AddCommand(NewMultiMergeBisectCommand())
+	multiMergeCmd.AddCommand(NewMultiMergeWatchCommand())
 
 	return multiMergeCmd
 }
@@ -206,6 +392,10 @@ func NewMultiMergeContinueCommand() *cobra.Command {
 				panic(err)
 			}
 
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
 			for {
 				manifest, err := repo.MultiMergeNamedContinue()
 				handleMultiMergeError(err)
@@ -214,8 +404,12 @@ func NewMultiMergeContinueCommand() *cobra.Command {
 					break
 				}
 			}
+
+			pushTarget(cmd, repo)
 		},
 	}
+	addPushFlag(multiMergeContinueCmd)
+
 	return multiMergeContinueCmd
 }
 
@@ -233,6 +427,10 @@ func NewMultiMergeAbortCommand() *cobra.Command {
 				panic(err)
 			}
 
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
 			err = repo.MultiMergeAbort()
 			cobra.CheckErr(err)
 		},
@@ -249,22 +447,153 @@ func NewMultiMergeShowCommand() *cobra.Command {
 		`)),
 		Run: func(cmd *cobra.Command, args []string) {
 			// Get handle on local repo
-			manifest, err := git.LoadMultiMergeManifest()
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
+			manifest, err := repo.LoadMultiMergeManifest()
 			cobra.CheckErr(err)
 
 			for _, reference := range manifest.References {
 				status := color.RedString("Not merged")
-				if reference.Merged {
+				if reference.Merged && reference.VerifyFailed {
+					status = color.RedString("Merged, verify failed")
+				} else if reference.Merged {
 					status = color.GreenString("Merged")
+				} else if reference.Skipped {
+					status = color.YellowString("Skipped, conflicts in %s", strings.Join(reference.ConflictingFiles, ", "))
+				}
+
+				// Flag branches that no longer match what went into the target
+				moved := ""
+				if reference.Sha != "" {
+					if sha := repo.ReferenceSha(manifest, reference.Name); sha == "" {
+						moved = color.YellowString(" (branch deleted since merge)")
+					} else if sha != reference.Sha {
+						moved = color.YellowString(" (moved since merge)")
+					}
 				}
 
-				fmt.Printf("%s %s\n", color.CyanString("%s", reference.Name), status)
+				mergeRequest := ""
+				if reference.Number != 0 {
+					mergeRequest = color.HiBlackString(" #%d %s", reference.Number, reference.URL)
+				}
+
+				fmt.Printf("%s %s%s%s\n", color.CyanString("%s", reference.Name), status, moved, mergeRequest)
 			}
 		},
 	}
 	return multiMergeShowCmd
 }
 
+func NewMultiMergeStatusCommand() *cobra.Command {
+	multiMergeStatusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show whether the target branch is behind its base and branches",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Compare the commits merged into the target branch with the branches
+			on the remote, to see at a glance whether a redo is needed.
+
+			For every branch the commit that was merged is shown next to the commit
+			the branch is at now, with the number of commits it is ahead of what was
+			merged, the number of merged commits it no longer has when it was reset
+			or rewritten, and whether it has been deleted or merged into the base
+			branch.
+		`)),
+		Run: func(cmd *cobra.Command, args []string) {
+			noFetch, _ := cmd.Flags().GetBool("no-fetch")
+
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
+			result, err := repo.MultiMergeStatus(!noFetch)
+			cobra.CheckErr(err)
+
+			printStatusResult(result)
+		},
+	}
+	multiMergeStatusCmd.Flags().Bool("no-fetch", false, "Compare with the remote branches as last fetched")
+
+	return multiMergeStatusCmd
+}
+
+// printStatusResult renders the status of every branch as a table, below the status of the base branch
+func printStatusResult(result *git.MultiMergeStatusResult) {
+	fmt.Println()
+	switch {
+	case result.MainSha == "":
+		fmt.Printf("%s %s\n", color.CyanString(result.BaseBranch), color.HiBlackString("at %.7s, the target has not been built yet", result.BaseSha))
+	case result.BaseAhead > 0:
+		fmt.Printf("%s %s\n", color.CyanString(result.BaseBranch), color.YellowString("%d commits ahead of %.7s the target was built on", result.BaseAhead, result.MainSha))
+	default:
+		fmt.Printf("%s %s\n", color.CyanString(result.BaseBranch), color.GreenString("at %.7s the target was built on", result.MainSha))
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.AppendHeader(table.Row{"Branch", "Merged", "Remote", "Ahead", "Behind", "Status"})
+	for _, reference := range result.References {
+		merged := color.RedString("not merged")
+		if reference.Skipped {
+			merged = color.YellowString("skipped")
+		} else if reference.MergedSha != "" {
+			merged = fmt.Sprintf("%.7s", reference.MergedSha)
+		}
+
+		remote := fmt.Sprintf("%.7s", reference.RemoteSha)
+		if reference.Deleted {
+			remote = color.YellowString("deleted")
+		}
+
+		ahead := ""
+		if reference.Ahead > 0 {
+			ahead = color.YellowString("+%d", reference.Ahead)
+		}
+		behind := ""
+		if reference.Behind > 0 {
+			behind = color.YellowString("-%d", reference.Behind)
+		}
+
+		status := color.GreenString("up to date")
+		switch {
+		case reference.MergedToBase:
+			status = color.YellowString("merged into %s", result.BaseBranch)
+		case reference.Deleted:
+			status = color.YellowString("branch deleted")
+		case reference.MergedSha == "":
+			status = ""
+		case reference.Behind > 0:
+			status = color.YellowString("rewritten since merge")
+		case reference.Ahead > 0:
+			status = color.YellowString("moved since merge")
+		}
+
+		t.AppendRow(table.Row{reference.Name, merged, remote, ahead, behind, status})
+	}
+	t.SetColumnConfigs([]table.ColumnConfig{{Number: 4, Align: text.AlignRight}, {Number: 5, Align: text.AlignRight}})
+
+	fmt.Println(t.Render())
+	fmt.Println()
+
+	if result.Stale() {
+		fmt.Println(color.YellowString("The target branch is out of date, run 'pila multi-merge redo' to rebuild it."))
+	} else {
+		fmt.Println(color.GreenString("The target branch is up to date."))
+	}
+}
+
 func NewMultiMergeRedoCommand() *cobra.Command {
 	multiMergeRedoCmd := &cobra.Command{
 		Use:     "redo",
@@ -273,23 +602,37 @@ func NewMultiMergeRedoCommand() *cobra.Command {
 		Long: strings.TrimSpace(dedent.Dedent(`
 			Load the existing manifest and reapply all merges from scratch.
 			This resets the target branch to the main branch and re-merges all branches in order.
+
+			With --frozen the target branch is rebuilt from the exact commits of the main branch
+			and of every branch recorded in the manifest, even if the branches have moved since.
 		`)),
 		Run: func(cmd *cobra.Command, args []string) {
+			frozen, _ := cmd.Flags().GetBool("frozen")
+
 			// Get handle on local repo
 			repo, err := git.GetLocalRepository()
 			if err != nil {
 				panic(err)
 			}
 
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
 			// Check if there's an ongoing merge
 			err = checkOngoingMerge(repo)
 			cobra.CheckErr(err)
 
-			err = repo.MultiMergeUsingManifest()
+			err = repo.MultiMergeUsingManifest(frozen)
 			handleMultiMergeError(err)
 			cobra.CheckErr(err)
+
+			pushTarget(cmd, repo)
 		},
 	}
+	multiMergeRedoCmd.Flags().Bool("frozen", false, "Rebuild from the commits recorded in the manifest instead of the current branches")
+	addPushFlag(multiMergeRedoCmd)
+
 	return multiMergeRedoCmd
 }
 
@@ -304,6 +647,8 @@ func NewMultiMergeAppendCommand() *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			branches, _ := cmd.Flags().GetStringSlice("branch")
 			target, _ := cmd.Flags().GetString("target")
+			referenceOptions, err := referenceOptionsFromFlags(cmd)
+			cobra.CheckErr(err)
 
 			// Get handle on local repo
 			repo, err := git.GetLocalRepository()
@@ -311,12 +656,16 @@ func NewMultiMergeAppendCommand() *cobra.Command {
 				panic(err)
 			}
 
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
 			// Check if there's an ongoing merge
 			err = checkOngoingMerge(repo)
 			cobra.CheckErr(err)
 
 			// Load existing manifest
-			manifest, err := git.LoadMultiMergeManifest()
+			manifest, err := repo.LoadMultiMergeManifest()
 			cobra.CheckErr(err)
 
 			if manifest.Type != git.MULTI_MERGE_MANIFEST_TYPE_BRANCHES {
@@ -345,10 +694,10 @@ func NewMultiMergeAppendCommand() *cobra.Command {
 				return
 			}
 
-			// Append new branches to existing ones
-			allBranches := append(existingBranches, newBranches...)
+			// Append new branches to existing ones, which keep their merge options
+			references := append(unmergedReferences(manifest.References), newReferences(newBranches, referenceOptions)...)
 
-			_, err = repo.MultiMergeNamedBranches(target, allBranches)
+			_, err = repo.MultiMergeReferences(target, references, manifest.Options)
 			handleMultiMergeError(err)
 			cobra.CheckErr(err)
 		},
@@ -362,6 +711,8 @@ func NewMultiMergeAppendCommand() *cobra.Command {
 	multiMergeAppendCmd.Flags().StringP("target", "T", "", "Target branch (inherits from manifest if not specified)")
 	multiMergeAppendCmd.RegisterFlagCompletionFunc("target", branchNameCompletions)
 
+	addReferenceOptionFlags(multiMergeAppendCmd)
+
 	return multiMergeAppendCmd
 }
 
@@ -376,6 +727,8 @@ func NewMultiMergePrependCommand() *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			branches, _ := cmd.Flags().GetStringSlice("branch")
 			target, _ := cmd.Flags().GetString("target")
+			referenceOptions, err := referenceOptionsFromFlags(cmd)
+			cobra.CheckErr(err)
 
 			// Get handle on local repo
 			repo, err := git.GetLocalRepository()
@@ -383,12 +736,16 @@ func NewMultiMergePrependCommand() *cobra.Command {
 				panic(err)
 			}
 
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
 			// Check if there's an ongoing merge
 			err = checkOngoingMerge(repo)
 			cobra.CheckErr(err)
 
 			// Load existing manifest
-			manifest, err := git.LoadMultiMergeManifest()
+			manifest, err := repo.LoadMultiMergeManifest()
 			cobra.CheckErr(err)
 
 			if manifest.Type != git.MULTI_MERGE_MANIFEST_TYPE_BRANCHES {
@@ -417,10 +774,10 @@ func NewMultiMergePrependCommand() *cobra.Command {
 				return
 			}
 
-			// Prepend new branches before existing ones
-			allBranches := append(newBranches, existingBranches...)
+			// Prepend new branches before existing ones, which keep their merge options
+			references := append(newReferences(newBranches, referenceOptions), unmergedReferences(manifest.References)...)
 
-			_, err = repo.MultiMergeNamedBranches(target, allBranches)
+			_, err = repo.MultiMergeReferences(target, references, manifest.Options)
 			handleMultiMergeError(err)
 			cobra.CheckErr(err)
 		},
@@ -434,12 +791,20 @@ func NewMultiMergePrependCommand() *cobra.Command {
 	multiMergePrependCmd.Flags().StringP("target", "T", "", "Target branch (inherits from manifest if not specified)")
 	multiMergePrependCmd.RegisterFlagCompletionFunc("target", branchNameCompletions)
 
+	addReferenceOptionFlags(multiMergePrependCmd)
+
 	return multiMergePrependCmd
 }
 
 func manifestBranchCompletions(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	repo, err := git.GetLocalRepository()
+	if err != nil {
+		panic(err)
+	}
+
 	// Load manifest to get branches
-	manifest, err := git.LoadMultiMergeManifest()
+	workDir, _ := repo.MultiMergeWorkDir()
+	manifest, err := git.LoadMultiMergeManifest(workDir)
 	if err != nil || manifest == nil {
 		return []string{}, cobra.ShellCompDirectiveNoFileComp
 	}
@@ -454,7 +819,7 @@ func manifestBranchCompletions(cmd *cobra.Command, args []string, toComplete str
 	return suggestions, cobra.ShellCompDirectiveNoFileComp
 }
 
-func writeResultToFile(filename string, result *git.MultiMergeTestResult) error {
+func writeResultToFile(filename string, result any) error {
 	data, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshalling result: %w", err)
@@ -471,8 +836,7 @@ func runMultiMergeTest() (*git.MultiMergeTestResult, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	if err := checkOngoingMerge(repo); err != nil {
+	if err := useMultiMergeWorkDir(repo); err != nil {
 		return nil, err
 	}
 
@@ -506,6 +870,116 @@ func printTestResult(result *git.MultiMergeTestResult) {
 	}
 }
 
+func runMultiMergeTestMatrix(outputFile string) {
+	repo, err := git.GetLocalRepository()
+	if err != nil {
+		panic(err)
+	}
+
+	var result *git.MultiMergeMatrixResult
+	err = useMultiMergeWorkDir(repo)
+	if err == nil {
+		result, err = repo.MultiMergeTestMatrix()
+	}
+	if err != nil {
+		result = &git.MultiMergeMatrixResult{
+			OK:       false,
+			Error:    err.Error(),
+			Branches: []string{},
+			Pairs:    []git.MultiMergePairResult{},
+		}
+	}
+	if outputFile != "" {
+		if writeErr := writeResultToFile(outputFile, result); writeErr != nil {
+			fmt.Fprintf(os.Stderr, "Error writing result file: %v\n", writeErr)
+			os.Exit(1)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	printMatrixResult(result)
+
+	if !result.OK {
+		os.Exit(1)
+	}
+}
+
+// printMatrixResult renders the pairwise results as a table, with the branches numbered along the top. Rows are
+// merged first, so a cell is the branch of its column merged after the branch of its row.
+func printMatrixResult(result *git.MultiMergeMatrixResult) {
+	header := table.Row{""}
+	for i := range result.Branches {
+		header = append(header, i+1)
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.AppendHeader(header)
+	for i, first := range result.Branches {
+		row := table.Row{fmt.Sprintf("%d %s", i+1, first)}
+		for _, second := range result.Branches {
+			pair, _ := result.Pair(first, second)
+			row = append(row, matrixCell(pair))
+		}
+		t.AppendRow(row)
+	}
+	columnConfigs := []table.ColumnConfig{}
+	for i := range result.Branches {
+		columnConfigs = append(columnConfigs, table.ColumnConfig{Number: i + 2, Align: text.AlignCenter, AlignHeader: text.AlignCenter})
+	}
+	t.SetColumnConfigs(columnConfigs)
+
+	fmt.Println()
+	fmt.Println(t.Render())
+	fmt.Println()
+
+	for _, pair := range result.Pairs {
+		switch pair.Status {
+		case git.MULTI_MERGE_PAIR_CONFLICT:
+			if pair.First == pair.Second {
+				fmt.Printf("%s conflicts with the base\n", color.RedString(pair.First))
+			} else {
+				fmt.Printf("%s conflicts when merged after %s\n", color.RedString(pair.Second), color.RedString(pair.First))
+			}
+			for _, f := range pair.ConflictingFiles {
+				fmt.Printf("  %s\n", f)
+			}
+		case git.MULTI_MERGE_PAIR_ERROR:
+			fmt.Printf("%s %s\n", color.RedString("%s + %s", pair.First, pair.Second), color.HiBlackString("(error)"))
+			fmt.Printf("  %s\n", pair.Error)
+		case git.MULTI_MERGE_PAIR_MISSING:
+			if pair.First == pair.Second {
+				fmt.Printf("%s %s\n", color.YellowString(pair.First), color.HiBlackString("(missing)"))
+			}
+		}
+	}
+
+	if result.OK {
+		fmt.Println(color.GreenString("No branches conflict with each other."))
+	} else {
+		fmt.Println(color.RedString("Some branches have conflicts."))
+	}
+}
+
+// matrixCell is the symbol for a pair in the matrix
+func matrixCell(pair git.MultiMergePairResult) string {
+	switch pair.Status {
+	case git.MULTI_MERGE_PAIR_CLEAN:
+		return color.GreenString("✓")
+	case git.MULTI_MERGE_PAIR_CONFLICT:
+		return color.RedString("✗ %d", len(pair.ConflictingFiles))
+	case git.MULTI_MERGE_PAIR_ERROR:
+		return color.RedString("!")
+	case git.MULTI_MERGE_PAIR_MISSING:
+		return color.YellowString("?")
+	default:
+		return color.HiBlackString("-")
+	}
+}
+
 func NewMultiMergeTestCommand() *cobra.Command {
 	multiMergeTestCmd := &cobra.Command{
 		Use:   "test",
@@ -513,10 +987,22 @@ func NewMultiMergeTestCommand() *cobra.Command {
 		Long: strings.TrimSpace(dedent.Dedent(`
 			Simulate the multi-merge process and report which branches
 			merge cleanly and which have conflicts, without modifying any branches.
+
+			The merges are done in memory, HEAD, the index and the working tree
+			are left untouched, so it is safe to run with uncommitted changes
+			or in the middle of a merge.
+
+			With --matrix every pair of branches is merged onto the base instead, in
+			both orders, showing which branches conflict when merged after which.
 		`)),
 		Run: func(cmd *cobra.Command, args []string) {
 			outputFile, _ := cmd.Flags().GetString("output")
 
+			if matrix, _ := cmd.Flags().GetBool("matrix"); matrix {
+				runMultiMergeTestMatrix(outputFile)
+				return
+			}
+
 			result, err := runMultiMergeTest()
 			if err != nil {
 				if result == nil {
@@ -550,10 +1036,204 @@ func NewMultiMergeTestCommand() *cobra.Command {
 		},
 	}
 	multiMergeTestCmd.Flags().StringP("output", "O", "", "Write JSON results to the specified file")
+	multiMergeTestCmd.Flags().Bool("matrix", false, "Merge every pair of branches and show which pairs conflict")
 
 	return multiMergeTestCmd
 }
 
+func NewMultiMergeReorderCommand() *cobra.Command {
+	multiMergeReorderCmd := &cobra.Command{
+		Use:   "reorder",
+		Short: "Reorder the branches in the manifest so they merge cleanly",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Search for an order of the branches in the manifest that merges cleanly,
+			and rewrite the manifest in that order. The merges are done in memory
+			like 'pila multi-merge test', and the target branch is left untouched,
+			run 'pila multi-merge redo' to rebuild it in the new order.
+
+			Pairs of branches conflicting with each other conflict in any order,
+			when there are any no search is done and the pairs are listed instead.
+
+			Pinned branches keep their position, pin them with --pin or by setting
+			pinned in the manifest.
+
+			Without --auto the order found is only shown, and the manifest is left as it is.
+		`)),
+		Run: func(cmd *cobra.Command, args []string) {
+			auto, _ := cmd.Flags().GetBool("auto")
+			pins, _ := cmd.Flags().GetStringSlice("pin")
+
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
+			// Check if there's an ongoing merge
+			err = checkOngoingMerge(repo)
+			cobra.CheckErr(err)
+
+			result, err := repo.MultiMergeReorder(pins, auto)
+			cobra.CheckErr(err)
+
+			fmt.Println()
+			if !result.Found {
+				for _, pair := range result.ConflictingPairs {
+					if pair.First == pair.Second {
+						fmt.Printf("%s %s\n", color.RedString(pair.First), color.HiBlackString("conflicts with the base"))
+					} else {
+						fmt.Printf("%s %s %s\n", color.RedString(pair.First), color.HiBlackString("conflicts with"), color.RedString(pair.Second))
+					}
+					for _, file := range pair.ConflictingFiles {
+						fmt.Printf("  %s\n", file)
+					}
+					if pair.Error != "" {
+						fmt.Printf("  %s\n", pair.Error)
+					}
+				}
+				if len(result.ConflictingPairs) > 0 {
+					fmt.Println()
+				}
+				fmt.Println(color.RedString("No order of the branches merges cleanly."))
+				os.Exit(1)
+			}
+
+			for i, branchName := range result.Order {
+				fmt.Printf("%s %s\n", color.HiBlackString("%d.", i+1), color.GreenString(branchName))
+			}
+			fmt.Println()
+			if !result.Changed {
+				fmt.Println(color.GreenString("The branches already merge cleanly in this order."))
+				return
+			}
+			if !auto {
+				fmt.Println(color.GreenString("The branches merge cleanly in this order, run with --auto to rewrite the manifest in it."))
+				return
+			}
+			fmt.Println(color.GreenString("Manifest reordered, run 'pila multi-merge redo' to rebuild the target branch."))
+		},
+	}
+	multiMergeReorderCmd.Flags().Bool("auto", false, "Rewrite the manifest in the order found")
+	multiMergeReorderCmd.Flags().StringSlice("pin", []string{}, "Keep branch at its position in the manifest")
+	multiMergeReorderCmd.RegisterFlagCompletionFunc("pin", manifestBranchCompletions)
+
+	return multiMergeReorderCmd
+}
+
+func NewMultiMergeBisectCommand() *cobra.Command {
+	multiMergeBisectCmd := &cobra.Command{
+		Use:   "bisect",
+		Short: "Find the branch in the manifest that breaks a command",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Find the first branch in the manifest after which a command fails,
+			e.g. the test suite, by binary searching through the base branch with
+			more and more of the branches merged.
+
+			The merges are done in memory like 'pila multi-merge test', and the
+			command is run in a scratch worktree that is removed afterwards, so
+			the checkout and the target branch are left untouched.
+
+			With --frozen the commits recorded in the manifest are used, to bisect
+			the target branch exactly as it was built.
+		`)),
+		Run: func(cmd *cobra.Command, args []string) {
+			command, _ := cmd.Flags().GetString("exec")
+			frozen, _ := cmd.Flags().GetBool("frozen")
+
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
+			result, err := repo.MultiMergeBisect(command, frozen)
+			cobra.CheckErr(err)
+
+			fmt.Println()
+			for _, branchName := range result.Skipped {
+				fmt.Printf("%s %s\n", color.YellowString(branchName), color.HiBlackString("(skipped, conflicts)"))
+			}
+			switch {
+			case result.BaseBad:
+				fmt.Println(color.RedString("The command already fails on the base branch."))
+				os.Exit(1)
+			case result.Culprit == "":
+				fmt.Println(color.GreenString("The command succeeds with all branches merged."))
+			default:
+				fmt.Printf("%s %s\n", color.RedString(result.Culprit), color.HiBlackString("is the first branch the command fails with"))
+				if len(result.LastGood) == 0 {
+					fmt.Printf("%s\n", color.HiBlackString("The command succeeds on the base branch alone"))
+				} else {
+					fmt.Printf("%s %s\n", color.HiBlackString("The command succeeds with"), color.GreenString(strings.Join(result.LastGood, ", ")))
+				}
+				os.Exit(1)
+			}
+		},
+	}
+	multiMergeBisectCmd.Flags().String("exec", "", "Command to run, failing when it exits non-zero")
+	multiMergeBisectCmd.MarkFlagRequired("exec")
+	multiMergeBisectCmd.Flags().Bool("frozen", false, "Merge the commits recorded in the manifest instead of the current branches")
+
+	return multiMergeBisectCmd
+}
+
+func NewMultiMergeWatchCommand() *cobra.Command {
+	multiMergeWatchCmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Redo the multi-merge whenever the base or the branches change",
+		Long: strings.TrimSpace(dedent.Dedent(`
+			Fetch the remotes at every interval, and redo the multi-merge when the
+			base branch or any of the branches has moved since the target branch
+			was last built. Runs until interrupted.
+
+			When a branch conflicts, or the verify command fails, the target branch
+			is put back the way it was, the multi-merge-failed.sh hook is run, and
+			the multi-merge is retried once something changes again.
+		`)),
+		Run: func(cmd *cobra.Command, args []string) {
+			interval, _ := cmd.Flags().GetDuration("interval")
+			push, _ := cmd.Flags().GetBool("push")
+
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
+			// Check if there's an ongoing merge
+			err = checkOngoingMerge(repo)
+			cobra.CheckErr(err)
+
+			var inputs *git.MultiMergeInputs
+			for {
+				inputs, _, err = repo.MultiMergeWatchTick(inputs, push)
+				if err != nil {
+					repo.Err("%s", err)
+				}
+
+				repo.Note("Next check at %s", time.Now().Add(interval).Format(time.TimeOnly))
+				time.Sleep(interval)
+			}
+		},
+	}
+	multiMergeWatchCmd.Flags().Duration("interval", 5*time.Minute, "Time between checks, e.g. 30s, 5m or 1h")
+	multiMergeWatchCmd.Flags().Bool("push", false, "Push the target branch after every rebuild")
+
+	return multiMergeWatchCmd
+}
+
 func NewMultiMergeRemoveCommand() *cobra.Command {
 	multiMergeRemoveCmd := &cobra.Command{
 		Use:   "remove <branch>",
@@ -567,8 +1247,18 @@ func NewMultiMergeRemoveCommand() *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			branchToRemove := args[0]
 
+			// Get handle on local repo
+			repo, err := git.GetLocalRepository()
+			if err != nil {
+				panic(err)
+			}
+
+			// Run in the multi-merge worktree, when the manifest is there
+			err = useMultiMergeWorkDir(repo)
+			cobra.CheckErr(err)
+
 			// Load existing manifest
-			manifest, err := git.LoadMultiMergeManifest()
+			manifest, err := repo.LoadMultiMergeManifest()
 			cobra.CheckErr(err)
 
 			// Find and remove the branch
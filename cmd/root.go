@@ -44,6 +44,7 @@ func NewRootCommand() *cobra.Command {
 	}
 	rootCmd.AddCommand(debugCmd)
 	rootCmd.AddCommand(
+		NewBranchCommand(),
 		NewMultiMergeCommand(),
 		NewVersionCommand(),
 	)
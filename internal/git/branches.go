@@ -2,25 +2,25 @@ package git
 
 import (
 	"fmt"
-	"os/exec"
 	"strings"
 )
 
-func Heads() (map[string]string, error) {
+// Heads returns all local branches mapped to the sha they point to
+func (r *LocalRepository) Heads() (map[string]string, error) {
 	heads := make(map[string]string)
 
-	cmd := exec.Command("git", "show-ref", "--branches")
-	stdout, err := cmd.Output()
+	output, err := r.ExecuteGitCommandQuiet("for-each-ref", "--format=%(objectname) %(refname:short)", "refs/heads/")
 	if err != nil {
-		fmt.Println(err.Error())
-		return heads, err
+		return heads, fmt.Errorf("listing branches: %s", output)
+	}
+	if output == "" {
+		return heads, nil
 	}
 
-	lines := strings.Split(strings.TrimSpace(string(stdout)), "\n")
+	lines := strings.Split(output, "\n")
 	for _, line := range lines {
-		parts := strings.Split(line, " ")
-		parts[1] = strings.Replace(parts[1], "refs/heads/", "", 1)
-		heads[parts[1]] = parts[0]
+		sha, branchName, _ := strings.Cut(line, " ")
+		heads[branchName] = sha
 	}
 
 	return heads, nil
@@ -69,8 +69,8 @@ func (r *LocalRepository) NamedBranches(ref string) (map[string]string, error) {
 	return heads, nil
 }
 
-// CheckBranchesHaveRemotes returns branch names that exist only locally (no origin/)
-func (r *LocalRepository) CheckBranchesHaveRemotes(branchNames []string) ([]string, error) {
+// CheckBranchesHaveRemotes returns branch names that exist only locally, not on the remote
+func (r *LocalRepository) CheckBranchesHaveRemotes(remote string, branchNames []string) ([]string, error) {
 	localOnlyBranches := []string{}
 
 	for _, branchName := range branchNames {
@@ -79,7 +79,7 @@ func (r *LocalRepository) CheckBranchesHaveRemotes(branchNames []string) ([]stri
 			continue // Branch doesn't exist at all
 		}
 
-		remoteBranchName := fmt.Sprintf("origin/%s", branchName)
+		remoteBranchName := fmt.Sprintf("%s/%s", remote, branchName)
 		_, hasRemote := heads[remoteBranchName]
 		_, hasLocal := heads[branchName]
 
@@ -91,140 +91,47 @@ func (r *LocalRepository) CheckBranchesHaveRemotes(branchNames []string) ([]stri
 	return localOnlyBranches, nil
 }
 
-func (r *LocalRepository) MainBranchName() (string, error) {
-	output, err := r.ExecuteGitCommandQuiet("rev-parse", "--abbrev-ref", "origin/HEAD")
-	if err != nil {
-		return "", fmt.Errorf("%s", output)
-	}
-
-	mainBranchName := strings.Replace(output, "origin/", "", 1)
-
-	return mainBranchName, nil
+// BaseRemote returns the remote the main branch is taken from, the remote.base config setting or origin
+func (r *LocalRepository) BaseRemote() string {
+	return configString("remote.base", "origin")
 }
 
-func CheckedOutBranchName() (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-	stdout, err := cmd.Output()
-	if err != nil {
-		fmt.Println(err.Error())
-		return "", err
-	}
-
-	checkedOutBranchName := strings.TrimSpace(string(stdout))
-
-	return checkedOutBranchName, nil
+// FeaturesRemote returns the remote feature branches are taken from, the remote.features config setting or origin
+func (r *LocalRepository) FeaturesRemote() string {
+	return configString("remote.features", "origin")
 }
 
-func GetBranchStack(mainBranchName, checkedOutBranchName string) ([]string, error) {
-	// Get list of known local branches
-	heads, err := Heads()
-	if err != nil {
-		return []string{}, err
-	}
-
-	// Create lookup maps for known local branches
-	shaToBranches := make(map[string]string)
-	branches := []string{}
-	for branch, sha := range heads {
-		branches = append(branches, branch)
-		shaToBranches[sha] = branch
-	}
+// MainBranchName returns the default branch of the base remote
+func (r *LocalRepository) MainBranchName() (string, error) {
+	return r.remoteMainBranchName(r.BaseRemote())
+}
 
-	mainSha, err := GetSha(mainBranchName)
+// remoteMainBranchName returns the branch the HEAD of a remote points to
+func (r *LocalRepository) remoteMainBranchName(remote string) (string, error) {
+	output, err := r.ExecuteGitCommandQuiet("rev-parse", "--abbrev-ref", fmt.Sprintf("%s/HEAD", remote))
 	if err != nil {
-		return []string{}, err
-	}
-	headSha, err := GetSha(checkedOutBranchName)
-	if err != nil {
-		return []string{}, err
-	}
-
-	if mainSha == headSha && mainBranchName != checkedOutBranchName {
-		return []string{mainBranchName, checkedOutBranchName}, nil
+		return "", fmt.Errorf("%s", output)
 	}
 
-	// Find merge base for known local branches
-	args := append([]string{"merge-base", "--octopus"}, branches...)
-	cmd := exec.Command("git", args...)
-	stdout, err := cmd.Output()
-	if err != nil {
-		fmt.Println(err.Error())
-		return []string{}, err
-	}
-	mergeBase := strings.TrimSpace(string(stdout))
+	mainBranchName := strings.TrimPrefix(output, remote+"/")
 
-	// Only one branch exists
-	if mergeBase == heads[mainBranchName] {
-		return []string{mainBranchName}, nil
-	}
+	return mainBranchName, nil
+}
 
-	// Use merge base to find all parent->child relationships
-	args = append([]string{"rev-list", "--parents"}, branches...)
-	args = append(args, fmt.Sprintf("^%s~1", mergeBase))
-	cmd = exec.Command("git", args...)
-	stdout, err = cmd.Output()
+func (r *LocalRepository) CheckedOutBranchName() (string, error) {
+	output, err := r.ExecuteGitCommandQuiet("rev-parse", "--abbrev-ref", "HEAD")
 	if err != nil {
-		fmt.Println(err.Error())
-		return []string{}, err
-	}
-
-	// Create parent <-> child lookup maps
-	parentToChild := make(map[string]string)
-	childToParent := make(map[string]string)
-	lines := strings.Split(strings.TrimSpace(string(stdout)), "\n")
-	for _, line := range lines {
-		parts := strings.Split(line, " ")
-		parentToChild[parts[0]] = parts[1]
-		childToParent[parts[1]] = parts[0]
-	}
-
-	// Build branch stack
-	branchStack := []string{}
-
-	// Find parents
-	currentSha := headSha
-	for {
-		if branch, ok := shaToBranches[currentSha]; ok {
-			branchStack = append([]string{branch}, branchStack...)
-		}
-
-		// Exit when we reach the main branch
-		if currentSha == mainSha {
-			break
-		}
-
-		if nextSha, ok := parentToChild[currentSha]; ok {
-			currentSha = nextSha
-		} else {
-			break
-		}
-	}
-
-	// Find children
-	currentSha = headSha
-	for {
-		if branch, ok := shaToBranches[currentSha]; ok && currentSha != headSha {
-			branchStack = append(branchStack, branch)
-		}
-
-		if nextSha, ok := childToParent[currentSha]; ok {
-			currentSha = nextSha
-		} else {
-			break
-		}
+		return "", fmt.Errorf("%s", output)
 	}
 
-	return branchStack, nil
+	return output, nil
 }
 
-func GetSha(branchName string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", branchName)
-	stdout, err := cmd.Output()
+func (r *LocalRepository) GetSha(branchName string) (string, error) {
+	output, err := r.ExecuteGitCommandQuiet("rev-parse", "--verify", branchName)
 	if err != nil {
-		fmt.Println(err.Error())
-		return "", err
+		return "", fmt.Errorf("unable to resolve '%s': %s", branchName, strings.TrimSpace(output))
 	}
-	sha := strings.TrimSpace(string(stdout))
 
-	return sha, nil
+	return output, nil
 }
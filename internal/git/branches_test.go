@@ -0,0 +1,27 @@
+package git
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMainBranchName_BaseRemote(t *testing.T) {
+	repo := newTestRepository(t)
+	gitCommand(t, "branch", "-m", "trunk")
+
+	upstreamDir := filepath.Join(t.TempDir(), "upstream.git")
+	gitCommand(t, "init", "-q", "--bare", upstreamDir)
+	gitCommand(t, "remote", "add", "upstream", upstreamDir)
+	gitCommand(t, "push", "-q", "upstream", "trunk")
+	gitCommand(t, "remote", "set-head", "upstream", "trunk")
+
+	useRemoteConfig(t)
+
+	mainBranchName, err := repo.MainBranchName()
+	if err != nil {
+		t.Fatalf("MainBranchName() error = %v", err)
+	}
+	if mainBranchName != "trunk" {
+		t.Errorf("MainBranchName() = %s, want trunk", mainBranchName)
+	}
+}
@@ -0,0 +1,275 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	FORGE_TYPE_GITHUB = "github"
+	FORGE_TYPE_GITLAB = "gitlab"
+	FORGE_TYPE_GITEA  = "gitea"
+
+	COMMIT_STATUS_PENDING = "pending"
+	COMMIT_STATUS_SUCCESS = "success"
+	COMMIT_STATUS_FAILURE = "failure"
+	COMMIT_STATUS_ERROR   = "error"
+)
+
+// Forge is a code hosting service with merge requests, e.g. GitHub, GitLab or Gitea
+type Forge interface {
+	// Type returns one of the FORGE_TYPE_* constants
+	Type() string
+
+	// MergeRequestsWithLabels returns open merge requests carrying all labels, ordered by creation date
+	MergeRequestsWithLabels(labels []string) ([]MergeRequest, error)
+
+	// MergeRequest returns a single merge request by its number
+	MergeRequest(number int) (*MergeRequest, error)
+
+	// OpenMergeRequestForBranch returns the open merge request from a branch of this repository, or nil
+	OpenMergeRequestForBranch(branchName string) (*MergeRequest, error)
+
+	// CreateMergeRequest opens a new merge request
+	CreateMergeRequest(options MergeRequestOptions) (*MergeRequest, error)
+
+	// UpdateMergeRequest changes the target branch and description of a merge request, and the title when set
+	UpdateMergeRequest(number int, options MergeRequestOptions) (*MergeRequest, error)
+
+	// CreateComment adds a comment to a merge request
+	CreateComment(number int, body string) error
+
+	// SetCommitStatus sets a status on a commit, the way CI systems do
+	SetCommitStatus(sha string, status CommitStatus) error
+}
+
+var (
+	_ Forge = (*GitHubClient)(nil)
+	_ Forge = (*GitLabClient)(nil)
+	_ Forge = (*GiteaClient)(nil)
+)
+
+// MergeRequest is a merge request (or pull request) as reported by the forge
+type MergeRequest struct {
+	Number       int
+	Title        string
+	SourceBranch string
+	TargetBranch string
+	URL          string
+	Description  string
+	Labels       []string
+	CreatedAt    time.Time
+	Ref          string // Ref to fetch from the base remote, when the source branch lives in a fork
+}
+
+// MergeRequestOptions are the fields set when creating or updating a merge request
+type MergeRequestOptions struct {
+	SourceBranch string // Only used when creating
+	TargetBranch string
+	Title        string
+	Description  string
+}
+
+// CommitStatus is a status reported on a commit
+type CommitStatus struct {
+	State       string // One of the COMMIT_STATUS_* constants
+	Context     string // Name of the status, e.g. pila/multi-merge
+	Description string
+	TargetURL   string
+}
+
+// Forge returns a client for the forge the features remote is hosted on, where stacks are published
+func (r *LocalRepository) Forge() (Forge, error) {
+	return r.RemoteForge(r.FeaturesRemote())
+}
+
+// RemoteForge returns a client for the forge a remote is hosted on
+func (r *LocalRepository) RemoteForge(remoteName string) (Forge, error) {
+	forgeType, err := r.ForgeType(remoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch forgeType {
+	case FORGE_TYPE_GITHUB:
+		return r.GitHubClient(remoteName)
+	case FORGE_TYPE_GITLAB:
+		return r.GitLabClient(remoteName)
+	case FORGE_TYPE_GITEA:
+		return r.GiteaClient(remoteName)
+	}
+
+	return nil, fmt.Errorf("unknown forge type '%s'", forgeType)
+}
+
+// ForgeType figures out which kind of forge the remote is hosted on.
+//
+// The forge.type config key takes precedence, otherwise well known hosts are recognized by name,
+// and anything else is probed over HTTP. Probe results are cached in the remote.<name>.pila-forge
+// git config key together with the URL that was probed, so we only probe again when the URL of the
+// remote, or forge.url, changes.
+func (r *LocalRepository) ForgeType(remoteName string) (string, error) {
+	if forgeType := configString("forge.type", ""); forgeType != "" {
+		switch forgeType {
+		case FORGE_TYPE_GITHUB, FORGE_TYPE_GITLAB, FORGE_TYPE_GITEA:
+			r.Type = forgeType
+			return forgeType, nil
+		}
+		return "", fmt.Errorf("unknown forge.type '%s', must be one of %s, %s or %s", forgeType, FORGE_TYPE_GITHUB, FORGE_TYPE_GITLAB, FORGE_TYPE_GITEA)
+	}
+
+	webURL, _, err := r.forgeLocation(remoteName)
+	if err != nil {
+		return "", err
+	}
+
+	// Cached as "<type> <web URL>"
+	cacheKey := fmt.Sprintf("remote.%s.pila-forge", remoteName)
+	if cached, err := r.ExecuteGitCommandQuiet("config", "--get", cacheKey); err == nil {
+		if cachedType, cachedURL, found := strings.Cut(cached, " "); found && cachedURL == webURL {
+			r.Type = cachedType
+			return cachedType, nil
+		}
+	}
+
+	forgeType, err := detectForgeType(webURL)
+	if err != nil {
+		return "", err
+	}
+	r.Type = forgeType
+
+	if _, err := r.ExecuteGitCommandQuiet("config", cacheKey, fmt.Sprintf("%s %s", forgeType, webURL)); err != nil {
+		r.Warn("Unable to cache forge type in %s", cacheKey)
+	}
+
+	return forgeType, nil
+}
+
+// forgeLocation returns the web URL of the forge hosting the remote, and the repository path on it.
+// The web URL can be overridden using the forge.url config key.
+func (r *LocalRepository) forgeLocation(remoteName string) (webURL, path string, err error) {
+	remoteURL, err := r.RemoteURL(remoteName)
+	if err != nil {
+		return "", "", err
+	}
+
+	scheme, host, path, err := parseRemoteURL(remoteURL)
+	if err != nil {
+		return "", "", err
+	}
+	if scheme != "http" {
+		scheme = "https"
+	}
+
+	webURL = configString("forge.url", fmt.Sprintf("%s://%s", scheme, host))
+
+	return strings.TrimSuffix(webURL, "/"), path, nil
+}
+
+// detectForgeType recognizes well known hosts, and probes the web URL for anything else
+func detectForgeType(webURL string) (string, error) {
+	forgeURL, err := url.Parse(webURL)
+	if err != nil {
+		return "", err
+	}
+
+	switch forgeURL.Hostname() {
+	case "github.com":
+		return FORGE_TYPE_GITHUB, nil
+	case "gitlab.com":
+		return FORGE_TYPE_GITLAB, nil
+	case "gitea.com", "codeberg.org":
+		return FORGE_TYPE_GITEA, nil
+	}
+
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   2 * time.Second, // Connect timeout
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   2 * time.Second,
+			ResponseHeaderTimeout: 2 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Return an error to stop following redirects
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// GitLab and GitHub Enterprise identify themselves in the response headers
+	resp, err := client.Head(webURL + "/")
+	if err == nil {
+		resp.Body.Close()
+		if resp.Header.Get("X-Gitlab-Meta") != "" {
+			return FORGE_TYPE_GITLAB, nil
+		}
+		if resp.Header.Get("X-GitHub-Request-Id") != "" || resp.Header.Get("X-GitHub-Enterprise-Version") != "" {
+			return FORGE_TYPE_GITHUB, nil
+		}
+	}
+
+	// Gitea (and its forks) answer the version endpoint without authentication
+	resp, err = client.Get(webURL + "/api/v1/version")
+	if err == nil {
+		defer resp.Body.Close()
+		var version struct {
+			Version string `json:"version"`
+		}
+		if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&version) == nil && version.Version != "" {
+			return FORGE_TYPE_GITEA, nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to detect which forge %s is, please set forge.type in the config", webURL)
+}
+
+// forgeRequest performs a forge API request, sending body as JSON when given and decoding the JSON response into v
+func forgeRequest(client *http.Client, forgeType, method, apiURL string, header http.Header, body, v any) (http.Header, error) {
+	var requestBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		requestBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, apiURL, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", forgeType, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: %s %s: %s", forgeType, method, req.URL.Path, resp.Status)
+	}
+
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return nil, fmt.Errorf("%s: decoding response: %w", forgeType, err)
+		}
+	}
+
+	return resp.Header, nil
+}
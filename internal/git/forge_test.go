@@ -0,0 +1,157 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.olrik.dev/pila/internal/core"
+)
+
+func TestDetectForgeType_KnownHosts(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com":   FORGE_TYPE_GITHUB,
+		"https://gitlab.com":   FORGE_TYPE_GITLAB,
+		"https://codeberg.org": FORGE_TYPE_GITEA,
+	}
+
+	for webURL, want := range tests {
+		got, err := detectForgeType(webURL)
+		if err != nil {
+			t.Errorf("detectForgeType(%q) error = %v", webURL, err)
+		}
+		if got != want {
+			t.Errorf("detectForgeType(%q) = %q, want %q", webURL, got, want)
+		}
+	}
+}
+
+func TestDetectForgeType_Probe(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"gitlab", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Gitlab-Meta", `{"correlation_id":"abc"}`)
+		}, FORGE_TYPE_GITLAB},
+		{"github enterprise", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-GitHub-Request-Id", "abc")
+		}, FORGE_TYPE_GITHUB},
+		{"gitea", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/v1/version" {
+				fmt.Fprint(w, `{"version": "1.22.0"}`)
+			}
+		}, FORGE_TYPE_GITEA},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(tt.handler)
+		got, err := detectForgeType(server.URL)
+		server.Close()
+
+		if err != nil {
+			t.Errorf("%s: detectForgeType() error = %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: detectForgeType() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectForgeType_Unknown(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if forgeType, err := detectForgeType(server.URL); err == nil {
+		t.Errorf("detectForgeType() = %q, expected error for unknown forge", forgeType)
+	}
+}
+
+func TestForgeType_Cache(t *testing.T) {
+	repo := newTestRepository(t)
+
+	previousConfig := core.Config
+	t.Cleanup(func() { core.Config = previousConfig })
+	core.Config = viper.New()
+
+	probes := 0
+	gitlab := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes++
+		w.Header().Set("X-Gitlab-Meta", `{"correlation_id":"abc"}`)
+	}))
+	defer gitlab.Close()
+	gitea := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/version" {
+			fmt.Fprint(w, `{"version": "1.22.0"}`)
+		}
+	}))
+	defer gitea.Close()
+
+	gitCommand(t, "remote", "add", "origin", gitlab.URL+"/owner/repo.git")
+	for range 2 {
+		if forgeType, err := repo.ForgeType("origin"); err != nil || forgeType != FORGE_TYPE_GITLAB {
+			t.Fatalf("ForgeType() = %q, %v, want %q", forgeType, err, FORGE_TYPE_GITLAB)
+		}
+	}
+	if probes != 1 {
+		t.Errorf("probed %d times, want the second lookup cached", probes)
+	}
+
+	// Moving the remote to another forge probes it again
+	gitCommand(t, "remote", "set-url", "origin", gitea.URL+"/owner/repo.git")
+	if forgeType, err := repo.ForgeType("origin"); err != nil || forgeType != FORGE_TYPE_GITEA {
+		t.Fatalf("ForgeType() = %q, %v, want %q after changing the remote URL", forgeType, err, FORGE_TYPE_GITEA)
+	}
+
+	// And so does pointing forge.url elsewhere
+	core.Config.Set("forge.url", gitlab.URL)
+	if forgeType, err := repo.ForgeType("origin"); err != nil || forgeType != FORGE_TYPE_GITLAB {
+		t.Fatalf("ForgeType() = %q, %v, want %q after setting forge.url", forgeType, err, FORGE_TYPE_GITLAB)
+	}
+}
+
+func TestGitLabClient_SetCommitStatus_MapsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.EscapedPath() != "/api/v4/projects/group%2Fproject/statuses/abc123" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.EscapedPath())
+		}
+
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decoding request body: %v", err)
+		}
+		if body["state"] != "failed" || body["name"] != "pila/multi-merge" {
+			t.Errorf("unexpected body %v", body)
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+
+	client := NewGitLabClient(server.URL+"/api/v4", "", "group/project")
+	err := client.SetCommitStatus("abc123", CommitStatus{State: COMMIT_STATUS_FAILURE, Context: "pila/multi-merge"})
+	if err != nil {
+		t.Fatalf("SetCommitStatus() error = %v", err)
+	}
+}
+
+func TestGiteaClient_CreateComment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/repos/owner/repo/issues/4/comments" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "token secret" {
+			t.Errorf("Authorization = %q, want %q", got, "token secret")
+		}
+		fmt.Fprint(w, `{"id": 1}`)
+	}))
+	defer server.Close()
+
+	client := NewGiteaClient(server.URL+"/api/v1", "secret", "owner/repo")
+	if err := client.CreateComment(4, "Hello"); err != nil {
+		t.Fatalf("CreateComment() error = %v", err)
+	}
+}
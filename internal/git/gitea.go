@@ -0,0 +1,148 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// GiteaClient talks to the Gitea (or Forgejo) REST API on behalf of a single repository
+type GiteaClient struct {
+	BaseURL    string // API base URL, e.g. https://gitea.com/api/v1
+	Token      string
+	Repository string // Repository path, e.g. owner/repo
+	HTTPClient *http.Client
+}
+
+func NewGiteaClient(baseURL, token, repository string) *GiteaClient {
+	return &GiteaClient{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		Repository: repository,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GiteaClient creates a client for the Gitea repository the remote points to.
+//
+// The API URL, token and repository can be overridden using the gitea.api_url,
+// gitea.token and gitea.repository config keys.
+func (r *LocalRepository) GiteaClient(remoteName string) (*GiteaClient, error) {
+	webURL, path, err := r.forgeLocation(remoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := configString("gitea.api_url", webURL+"/api/v1")
+	token := configString("gitea.token", os.Getenv("GITEA_TOKEN"))
+	repository := configString("gitea.repository", path)
+
+	return NewGiteaClient(baseURL, token, repository), nil
+}
+
+func (c *GiteaClient) Type() string {
+	return FORGE_TYPE_GITEA
+}
+
+// MergeRequestsWithLabels returns all open pull requests carrying every one of the given labels,
+// ordered by creation date. Like on GitHub, pull requests from forks are fetched through refs/pull/<number>/head.
+func (c *GiteaClient) MergeRequestsWithLabels(labels []string) ([]MergeRequest, error) {
+	query := url.Values{}
+	query.Set("state", "open")
+	query.Set("sort", "oldest")
+	query.Set("limit", "50")
+
+	return listPullRequestsWithLabels(labels, c.url("/pulls?"+query.Encode()), c.get)
+}
+
+func (c *GiteaClient) MergeRequest(number int) (*MergeRequest, error) {
+	var pr githubPullRequest
+	if _, err := c.get(c.url(fmt.Sprintf("/pulls/%d", number)), &pr); err != nil {
+		return nil, err
+	}
+
+	mergeRequest := pr.toMergeRequest()
+	return &mergeRequest, nil
+}
+
+// OpenMergeRequestForBranch looks through the open pull requests, as Gitea can't filter the listing on head branch
+func (c *GiteaClient) OpenMergeRequestForBranch(branchName string) (*MergeRequest, error) {
+	query := url.Values{}
+	query.Set("state", "open")
+	query.Set("limit", "50")
+
+	pullRequests, err := listPullRequests(c.url("/pulls?"+query.Encode()), c.get)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pr := range pullRequests {
+		mergeRequest := pr.toMergeRequest()
+		if mergeRequest.SourceBranch == branchName && mergeRequest.Ref == "" {
+			return &mergeRequest, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *GiteaClient) CreateMergeRequest(options MergeRequestOptions) (*MergeRequest, error) {
+	var pr githubPullRequest
+	if _, err := c.request(http.MethodPost, c.url("/pulls"), pullRequestPayload(options), &pr); err != nil {
+		return nil, err
+	}
+
+	mergeRequest := pr.toMergeRequest()
+	return &mergeRequest, nil
+}
+
+func (c *GiteaClient) UpdateMergeRequest(number int, options MergeRequestOptions) (*MergeRequest, error) {
+	options.SourceBranch = ""
+
+	var pr githubPullRequest
+	if _, err := c.request(http.MethodPatch, c.url(fmt.Sprintf("/pulls/%d", number)), pullRequestPayload(options), &pr); err != nil {
+		return nil, err
+	}
+
+	mergeRequest := pr.toMergeRequest()
+	return &mergeRequest, nil
+}
+
+func (c *GiteaClient) CreateComment(number int, body string) error {
+	_, err := c.request(http.MethodPost, c.url(fmt.Sprintf("/issues/%d/comments", number)), map[string]string{
+		"body": body,
+	}, nil)
+	return err
+}
+
+func (c *GiteaClient) SetCommitStatus(sha string, status CommitStatus) error {
+	_, err := c.request(http.MethodPost, c.url(fmt.Sprintf("/statuses/%s", sha)), map[string]string{
+		"state":       status.State,
+		"context":     status.Context,
+		"description": status.Description,
+		"target_url":  status.TargetURL,
+	}, nil)
+	return err
+}
+
+// url returns the absolute API URL of a path below the repository
+func (c *GiteaClient) url(path string) string {
+	return fmt.Sprintf("%s/repos/%s%s", c.BaseURL, c.Repository, path)
+}
+
+func (c *GiteaClient) get(apiURL string, v any) (http.Header, error) {
+	return c.request(http.MethodGet, apiURL, nil, v)
+}
+
+func (c *GiteaClient) request(method, apiURL string, body, v any) (http.Header, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	if c.Token != "" {
+		header.Set("Authorization", "token "+c.Token)
+	}
+
+	return forgeRequest(c.HTTPClient, FORGE_TYPE_GITEA, method, apiURL, header, body, v)
+}
@@ -0,0 +1,286 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GitHubClient talks to the GitHub REST API on behalf of a single repository
+type GitHubClient struct {
+	BaseURL    string // API base URL, e.g. https://api.github.com
+	Token      string
+	Repository string // Repository path, e.g. owner/repo
+	HTTPClient *http.Client
+}
+
+type githubLabel struct {
+	Name string `json:"name"`
+}
+
+type githubRepository struct {
+	FullName string `json:"full_name"`
+}
+
+type githubBranch struct {
+	Ref  string            `json:"ref"`
+	Sha  string            `json:"sha"`
+	Repo *githubRepository `json:"repo"` // nil when the fork has been deleted
+}
+
+// githubPullRequest is a pull request as returned by both GitHub and Gitea
+type githubPullRequest struct {
+	Number    int           `json:"number"`
+	Title     string        `json:"title"`
+	HTMLURL   string        `json:"html_url"`
+	Body      string        `json:"body"`
+	Labels    []githubLabel `json:"labels"`
+	Head      githubBranch  `json:"head"`
+	Base      githubBranch  `json:"base"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+var githubNextLinkRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+func NewGitHubClient(baseURL, token, repository string) *GitHubClient {
+	return &GitHubClient{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		Repository: repository,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GitHubClient creates a client for the GitHub repository the remote points to.
+//
+// The API URL, token and repository can be overridden using the github.api_url,
+// github.token and github.repository config keys.
+func (r *LocalRepository) GitHubClient(remoteName string) (*GitHubClient, error) {
+	webURL, path, err := r.forgeLocation(remoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	// github.com has a dedicated API host, GitHub Enterprise serves the API below /api/v3
+	defaultBaseURL := webURL + "/api/v3"
+	if forgeURL, err := url.Parse(webURL); err == nil && forgeURL.Hostname() == "github.com" {
+		defaultBaseURL = "https://api.github.com"
+	}
+
+	baseURL := configString("github.api_url", defaultBaseURL)
+	token := configString("github.token", os.Getenv("GITHUB_TOKEN"))
+	repository := configString("github.repository", path)
+
+	return NewGitHubClient(baseURL, token, repository), nil
+}
+
+func (c *GitHubClient) Type() string {
+	return FORGE_TYPE_GITHUB
+}
+
+// MergeRequestsWithLabels returns all open pull requests carrying every one of the given labels,
+// ordered by creation date. Pull requests from forks get a Ref pointing at refs/pull/<number>/head,
+// as their branch does not exist in this repository.
+func (c *GitHubClient) MergeRequestsWithLabels(labels []string) ([]MergeRequest, error) {
+	query := url.Values{}
+	query.Set("state", "open")
+	query.Set("sort", "created")
+	query.Set("direction", "asc")
+	query.Set("per_page", "100")
+
+	return listPullRequestsWithLabels(labels, c.url("/pulls?"+query.Encode()), c.get)
+}
+
+func (c *GitHubClient) MergeRequest(number int) (*MergeRequest, error) {
+	var pr githubPullRequest
+	if _, err := c.get(c.url(fmt.Sprintf("/pulls/%d", number)), &pr); err != nil {
+		return nil, err
+	}
+
+	mergeRequest := pr.toMergeRequest()
+	return &mergeRequest, nil
+}
+
+func (c *GitHubClient) OpenMergeRequestForBranch(branchName string) (*MergeRequest, error) {
+	// The head filter takes owner:branch, which also rules out forks with a branch of the same name
+	owner, _, _ := strings.Cut(c.Repository, "/")
+
+	query := url.Values{}
+	query.Set("state", "open")
+	query.Set("head", owner+":"+branchName)
+
+	var pullRequests []githubPullRequest
+	if _, err := c.get(c.url("/pulls?"+query.Encode()), &pullRequests); err != nil {
+		return nil, err
+	}
+	if len(pullRequests) == 0 {
+		return nil, nil
+	}
+
+	mergeRequest := pullRequests[0].toMergeRequest()
+	return &mergeRequest, nil
+}
+
+func (c *GitHubClient) CreateMergeRequest(options MergeRequestOptions) (*MergeRequest, error) {
+	var pr githubPullRequest
+	if _, err := c.request(http.MethodPost, c.url("/pulls"), pullRequestPayload(options), &pr); err != nil {
+		return nil, err
+	}
+
+	mergeRequest := pr.toMergeRequest()
+	return &mergeRequest, nil
+}
+
+func (c *GitHubClient) UpdateMergeRequest(number int, options MergeRequestOptions) (*MergeRequest, error) {
+	options.SourceBranch = ""
+
+	var pr githubPullRequest
+	if _, err := c.request(http.MethodPatch, c.url(fmt.Sprintf("/pulls/%d", number)), pullRequestPayload(options), &pr); err != nil {
+		return nil, err
+	}
+
+	mergeRequest := pr.toMergeRequest()
+	return &mergeRequest, nil
+}
+
+func (c *GitHubClient) CreateComment(number int, body string) error {
+	// Pull requests are issues as far as comments are concerned
+	_, err := c.request(http.MethodPost, c.url(fmt.Sprintf("/issues/%d/comments", number)), map[string]string{
+		"body": body,
+	}, nil)
+	return err
+}
+
+func (c *GitHubClient) SetCommitStatus(sha string, status CommitStatus) error {
+	_, err := c.request(http.MethodPost, c.url(fmt.Sprintf("/statuses/%s", sha)), map[string]string{
+		"state":       status.State,
+		"context":     status.Context,
+		"description": status.Description,
+		"target_url":  status.TargetURL,
+	}, nil)
+	return err
+}
+
+// url returns the absolute API URL of a path below the repository
+func (c *GitHubClient) url(path string) string {
+	return fmt.Sprintf("%s/repos/%s%s", c.BaseURL, c.Repository, path)
+}
+
+func (c *GitHubClient) get(apiURL string, v any) (http.Header, error) {
+	return c.request(http.MethodGet, apiURL, nil, v)
+}
+
+func (c *GitHubClient) request(method, apiURL string, body, v any) (http.Header, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.github+json")
+	header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	return forgeRequest(c.HTTPClient, FORGE_TYPE_GITHUB, method, apiURL, header, body, v)
+}
+
+// listPullRequestsWithLabels returns the pull requests of a listing carrying every one of the labels, ordered by
+// creation date. Shared by GitHub and Gitea, whose pulls endpoints can't filter on label names.
+func listPullRequestsWithLabels(labels []string, firstURL string, get func(apiURL string, v any) (http.Header, error)) ([]MergeRequest, error) {
+	if len(labels) == 0 {
+		return nil, errors.New("at least one label is required")
+	}
+
+	pullRequests, err := listPullRequests(firstURL, get)
+	if err != nil {
+		return nil, err
+	}
+
+	mergeRequests := []MergeRequest{}
+	for _, pr := range pullRequests {
+		mergeRequest := pr.toMergeRequest()
+		if containsAll(mergeRequest.Labels, labels) {
+			mergeRequests = append(mergeRequests, mergeRequest)
+		}
+	}
+
+	sort.SliceStable(mergeRequests, func(i, j int) bool {
+		return mergeRequests[i].CreatedAt.Before(mergeRequests[j].CreatedAt)
+	})
+
+	return mergeRequests, nil
+}
+
+// listPullRequests follows the Link headers of a pull request listing, returning the pull requests of every page
+func listPullRequests(firstURL string, get func(apiURL string, v any) (http.Header, error)) ([]githubPullRequest, error) {
+	pullRequests := []githubPullRequest{}
+	for next := firstURL; next != ""; {
+		var page []githubPullRequest
+		header, err := get(next, &page)
+		if err != nil {
+			return nil, err
+		}
+		pullRequests = append(pullRequests, page...)
+
+		next = ""
+		if match := githubNextLinkRegexp.FindStringSubmatch(header.Get("Link")); match != nil {
+			next = match[1]
+		}
+	}
+
+	return pullRequests, nil
+}
+
+// pullRequestPayload builds the body of a pull request create or update, shared by GitHub and Gitea.
+// Empty fields are left out, so they are not changed on update.
+func pullRequestPayload(options MergeRequestOptions) map[string]string {
+	payload := map[string]string{
+		"base": options.TargetBranch,
+		"body": options.Description,
+	}
+	if options.SourceBranch != "" {
+		payload["head"] = options.SourceBranch
+	}
+	if options.Title != "" {
+		payload["title"] = options.Title
+	}
+
+	return payload
+}
+
+func (pr githubPullRequest) toMergeRequest() MergeRequest {
+	labels := []string{}
+	for _, label := range pr.Labels {
+		labels = append(labels, label.Name)
+	}
+
+	mergeRequest := MergeRequest{
+		Number:       pr.Number,
+		Title:        pr.Title,
+		SourceBranch: pr.Head.Ref,
+		TargetBranch: pr.Base.Ref,
+		URL:          pr.HTMLURL,
+		Description:  pr.Body,
+		Labels:       labels,
+		CreatedAt:    pr.CreatedAt,
+	}
+	if pr.Head.Repo == nil || pr.Base.Repo == nil || pr.Head.Repo.FullName != pr.Base.Repo.FullName {
+		mergeRequest.Ref = fmt.Sprintf("refs/pull/%d/head", pr.Number)
+	}
+
+	return mergeRequest
+}
+
+// containsAll reports whether every one of wanted is in values
+func containsAll(values, wanted []string) bool {
+	for _, w := range wanted {
+		if !slices.Contains(values, w) {
+			return false
+		}
+	}
+	return true
+}
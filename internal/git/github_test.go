@@ -0,0 +1,61 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGitHubClient_MergeRequestsWithLabels(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/pulls" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer secret")
+		}
+
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s/repos/owner/repo/pulls?page=2>; rel="next", <%s/repos/owner/repo/pulls?page=2>; rel="last"`, server.URL, server.URL))
+			fmt.Fprint(w, `[
+				{"number": 5, "title": "Same repo", "html_url": "https://github.com/owner/repo/pull/5", "created_at": "2025-02-01T10:00:00Z",
+				 "labels": [{"name": "staging"}, {"name": "bug"}],
+				 "head": {"ref": "feature-a", "repo": {"full_name": "owner/repo"}}, "base": {"ref": "main", "repo": {"full_name": "owner/repo"}}},
+				{"number": 6, "title": "Unlabeled", "html_url": "https://github.com/owner/repo/pull/6", "created_at": "2025-01-15T10:00:00Z",
+				 "labels": [{"name": "bug"}],
+				 "head": {"ref": "feature-b", "repo": {"full_name": "owner/repo"}}, "base": {"ref": "main", "repo": {"full_name": "owner/repo"}}}
+			]`)
+		case "2":
+			fmt.Fprint(w, `[
+				{"number": 3, "title": "From fork", "html_url": "https://github.com/owner/repo/pull/3", "created_at": "2025-01-01T10:00:00Z",
+				 "labels": [{"name": "staging"}],
+				 "head": {"ref": "main", "repo": {"full_name": "someone/repo"}}, "base": {"ref": "main", "repo": {"full_name": "owner/repo"}}}
+			]`)
+		}
+	}))
+	defer server.Close()
+
+	client := NewGitHubClient(server.URL, "secret", "owner/repo")
+	mergeRequests, err := client.MergeRequestsWithLabels([]string{"staging"})
+	if err != nil {
+		t.Fatalf("MergeRequestsWithLabels() error = %v", err)
+	}
+
+	if len(mergeRequests) != 2 {
+		t.Fatalf("got %d pull requests, want 2", len(mergeRequests))
+	}
+
+	// Oldest first, and the fork is fetched through its pull ref
+	if mergeRequests[0].Number != 3 || mergeRequests[0].Ref != "refs/pull/3/head" {
+		t.Errorf("first pull request = #%d (ref %q), want #3 (ref %q)", mergeRequests[0].Number, mergeRequests[0].Ref, "refs/pull/3/head")
+	}
+	if mergeRequests[1].Number != 5 || mergeRequests[1].Ref != "" || mergeRequests[1].SourceBranch != "feature-a" {
+		t.Errorf("second pull request = #%d %s (ref %q), want #5 feature-a without ref", mergeRequests[1].Number, mergeRequests[1].SourceBranch, mergeRequests[1].Ref)
+	}
+	if mergeRequests[1].URL != "https://github.com/owner/repo/pull/5" {
+		t.Errorf("URL = %q, want %q", mergeRequests[1].URL, "https://github.com/owner/repo/pull/5")
+	}
+}
@@ -0,0 +1,220 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GitLabClient talks to the GitLab REST API on behalf of a single project
+type GitLabClient struct {
+	BaseURL    string // API base URL, e.g. https://gitlab.com/api/v4
+	Token      string
+	Project    string // Project path, e.g. group/project
+	HTTPClient *http.Client
+}
+
+type gitlabMergeRequest struct {
+	IID             int       `json:"iid"`
+	Title           string    `json:"title"`
+	SourceBranch    string    `json:"source_branch"`
+	TargetBranch    string    `json:"target_branch"`
+	SourceProjectID int       `json:"source_project_id"`
+	TargetProjectID int       `json:"target_project_id"`
+	WebURL          string    `json:"web_url"`
+	Description     string    `json:"description"`
+	Labels          []string  `json:"labels"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func NewGitLabClient(baseURL, token, project string) *GitLabClient {
+	return &GitLabClient{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		Project:    project,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GitLabClient creates a client for the GitLab project the remote points to.
+//
+// The API URL, token and project can be overridden using the gitlab.api_url,
+// gitlab.token and gitlab.project config keys.
+func (r *LocalRepository) GitLabClient(remoteName string) (*GitLabClient, error) {
+	webURL, path, err := r.forgeLocation(remoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := configString("gitlab.api_url", webURL+"/api/v4")
+	token := configString("gitlab.token", os.Getenv("GITLAB_TOKEN"))
+	project := configString("gitlab.project", path)
+
+	return NewGitLabClient(baseURL, token, project), nil
+}
+
+func (c *GitLabClient) Type() string {
+	return FORGE_TYPE_GITLAB
+}
+
+// MergeRequestsWithLabels returns all open merge requests carrying every one of the given labels,
+// ordered by creation date. Merge requests from forks get a Ref pointing at refs/merge-requests/<iid>/head,
+// as their branch does not exist in this project.
+func (c *GitLabClient) MergeRequestsWithLabels(labels []string) ([]MergeRequest, error) {
+	if len(labels) == 0 {
+		return nil, errors.New("at least one label is required")
+	}
+
+	query := url.Values{}
+	query.Set("state", "opened")
+	query.Set("labels", strings.Join(labels, ","))
+	query.Set("order_by", "created_at")
+	query.Set("sort", "asc")
+	query.Set("per_page", "100")
+
+	mergeRequests := []MergeRequest{}
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+
+		var gitlabMergeRequests []gitlabMergeRequest
+		header, err := c.request(http.MethodGet, "/merge_requests?"+query.Encode(), nil, &gitlabMergeRequests)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, mr := range gitlabMergeRequests {
+			mergeRequests = append(mergeRequests, mr.toMergeRequest())
+		}
+
+		page = header.Get("X-Next-Page")
+	}
+
+	// The API already sorts, but the order is part of our contract so don't rely on it
+	sort.SliceStable(mergeRequests, func(i, j int) bool {
+		return mergeRequests[i].CreatedAt.Before(mergeRequests[j].CreatedAt)
+	})
+
+	return mergeRequests, nil
+}
+
+func (c *GitLabClient) MergeRequest(number int) (*MergeRequest, error) {
+	var mr gitlabMergeRequest
+	if _, err := c.request(http.MethodGet, fmt.Sprintf("/merge_requests/%d", number), nil, &mr); err != nil {
+		return nil, err
+	}
+
+	mergeRequest := mr.toMergeRequest()
+	return &mergeRequest, nil
+}
+
+func (c *GitLabClient) OpenMergeRequestForBranch(branchName string) (*MergeRequest, error) {
+	query := url.Values{}
+	query.Set("state", "opened")
+	query.Set("source_branch", branchName)
+
+	var gitlabMergeRequests []gitlabMergeRequest
+	if _, err := c.request(http.MethodGet, "/merge_requests?"+query.Encode(), nil, &gitlabMergeRequests); err != nil {
+		return nil, err
+	}
+
+	// Forks can have a branch of the same name
+	for _, mr := range gitlabMergeRequests {
+		if mr.SourceProjectID == mr.TargetProjectID {
+			mergeRequest := mr.toMergeRequest()
+			return &mergeRequest, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *GitLabClient) CreateMergeRequest(options MergeRequestOptions) (*MergeRequest, error) {
+	var mr gitlabMergeRequest
+	if _, err := c.request(http.MethodPost, "/merge_requests", map[string]string{
+		"source_branch": options.SourceBranch,
+		"target_branch": options.TargetBranch,
+		"title":         options.Title,
+		"description":   options.Description,
+	}, &mr); err != nil {
+		return nil, err
+	}
+
+	mergeRequest := mr.toMergeRequest()
+	return &mergeRequest, nil
+}
+
+func (c *GitLabClient) UpdateMergeRequest(number int, options MergeRequestOptions) (*MergeRequest, error) {
+	payload := map[string]string{
+		"target_branch": options.TargetBranch,
+		"description":   options.Description,
+	}
+	if options.Title != "" {
+		payload["title"] = options.Title
+	}
+
+	var mr gitlabMergeRequest
+	if _, err := c.request(http.MethodPut, fmt.Sprintf("/merge_requests/%d", number), payload, &mr); err != nil {
+		return nil, err
+	}
+
+	mergeRequest := mr.toMergeRequest()
+	return &mergeRequest, nil
+}
+
+func (c *GitLabClient) CreateComment(number int, body string) error {
+	_, err := c.request(http.MethodPost, fmt.Sprintf("/merge_requests/%d/notes", number), map[string]string{
+		"body": body,
+	}, nil)
+	return err
+}
+
+func (c *GitLabClient) SetCommitStatus(sha string, status CommitStatus) error {
+	// GitLab has no "error" or "failure", only "failed"
+	state := status.State
+	if state == COMMIT_STATUS_FAILURE || state == COMMIT_STATUS_ERROR {
+		state = "failed"
+	}
+
+	_, err := c.request(http.MethodPost, fmt.Sprintf("/statuses/%s", sha), map[string]string{
+		"state":       state,
+		"name":        status.Context,
+		"description": status.Description,
+		"target_url":  status.TargetURL,
+	}, nil)
+	return err
+}
+
+// request performs a request against an API path below the project
+func (c *GitLabClient) request(method, path string, body, v any) (http.Header, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	if c.Token != "" {
+		header.Set("PRIVATE-TOKEN", c.Token)
+	}
+
+	apiURL := fmt.Sprintf("%s/projects/%s%s", c.BaseURL, url.PathEscape(c.Project), path)
+	return forgeRequest(c.HTTPClient, FORGE_TYPE_GITLAB, method, apiURL, header, body, v)
+}
+
+func (mr gitlabMergeRequest) toMergeRequest() MergeRequest {
+	mergeRequest := MergeRequest{
+		Number:       mr.IID,
+		Title:        mr.Title,
+		SourceBranch: mr.SourceBranch,
+		TargetBranch: mr.TargetBranch,
+		URL:          mr.WebURL,
+		Description:  mr.Description,
+		Labels:       mr.Labels,
+		CreatedAt:    mr.CreatedAt,
+	}
+	if mr.SourceProjectID != mr.TargetProjectID {
+		mergeRequest.Ref = fmt.Sprintf("refs/merge-requests/%d/head", mr.IID)
+	}
+
+	return mergeRequest
+}
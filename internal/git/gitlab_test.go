@@ -0,0 +1,93 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGitLabClient_MergeRequestsWithLabels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v4/projects/group%2Fproject/merge_requests" {
+			t.Errorf("unexpected path %s", r.URL.EscapedPath())
+		}
+		if got := r.Header.Get("PRIVATE-TOKEN"); got != "secret" {
+			t.Errorf("PRIVATE-TOKEN = %q, want %q", got, "secret")
+		}
+		query := r.URL.Query()
+		if query.Get("state") != "opened" || query.Get("labels") != "staging,qa" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+
+		// Serve two pages, newest first on the first page to prove we sort ourselves
+		switch query.Get("page") {
+		case "1":
+			w.Header().Set("X-Next-Page", "2")
+			fmt.Fprint(w, `[
+				{"iid": 12, "title": "Newer", "source_branch": "feature-b", "target_branch": "main", "created_at": "2025-02-01T10:00:00Z"},
+				{"iid": 7, "title": "Older", "source_branch": "feature-a", "target_branch": "main", "created_at": "2025-01-01T10:00:00Z"}
+			]`)
+		case "2":
+			fmt.Fprint(w, `[
+				{"iid": 20, "title": "Newest", "source_branch": "feature-c", "target_branch": "main", "created_at": "2025-03-01T10:00:00Z"}
+			]`)
+		default:
+			t.Errorf("unexpected page %q", query.Get("page"))
+		}
+	}))
+	defer server.Close()
+
+	client := NewGitLabClient(server.URL+"/api/v4/", "secret", "group/project")
+	mergeRequests, err := client.MergeRequestsWithLabels([]string{"staging", "qa"})
+	if err != nil {
+		t.Fatalf("MergeRequestsWithLabels() error = %v", err)
+	}
+
+	want := []struct {
+		number int
+		branch string
+	}{{7, "feature-a"}, {12, "feature-b"}, {20, "feature-c"}}
+	if len(mergeRequests) != len(want) {
+		t.Fatalf("got %d merge requests, want %d", len(mergeRequests), len(want))
+	}
+	for i, w := range want {
+		if mergeRequests[i].Number != w.number || mergeRequests[i].SourceBranch != w.branch {
+			t.Errorf("merge request %d = !%d %s, want !%d %s", i, mergeRequests[i].Number, mergeRequests[i].SourceBranch, w.number, w.branch)
+		}
+	}
+}
+
+func TestGitLabClient_MergeRequestsWithLabels_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "401 Unauthorized"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := NewGitLabClient(server.URL+"/api/v4", "", "group/project")
+	if _, err := client.MergeRequestsWithLabels([]string{"staging"}); err == nil {
+		t.Fatal("MergeRequestsWithLabels() expected error for 401 response, got nil")
+	}
+}
+
+func TestGitLabClient_OpenMergeRequestForBranch_IgnoresForks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("source_branch"); got != "feature-a" {
+			t.Errorf("source_branch = %q, want %q", got, "feature-a")
+		}
+		fmt.Fprint(w, `[
+			{"iid": 3, "source_branch": "feature-a", "source_project_id": 2, "target_project_id": 1},
+			{"iid": 4, "source_branch": "feature-a", "source_project_id": 1, "target_project_id": 1, "description": "Stacked"}
+		]`)
+	}))
+	defer server.Close()
+
+	client := NewGitLabClient(server.URL+"/api/v4", "secret", "group/project")
+	mergeRequest, err := client.OpenMergeRequestForBranch("feature-a")
+	if err != nil {
+		t.Fatalf("OpenMergeRequestForBranch() error = %v", err)
+	}
+	if mergeRequest == nil || mergeRequest.Number != 4 || mergeRequest.Description != "Stacked" {
+		t.Errorf("OpenMergeRequestForBranch() = %+v, want !4 from the project itself", mergeRequest)
+	}
+}
@@ -0,0 +1,83 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/spf13/viper"
+	"go.olrik.dev/pila/internal/core"
+)
+
+// newTestRepository creates a repository with a single commit on main in a temporary directory,
+// and changes into it for the duration of the test
+func newTestRepository(t *testing.T) *LocalRepository {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(dir, ".gitconfig-global"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	gitCommand(t, "init", "-q", "-b", "main")
+	gitCommand(t, "config", "user.name", "Pila Test")
+	gitCommand(t, "config", "user.email", "pila@example.com")
+	commitFile(t, "README.md", "main")
+
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		t.Fatalf("opening repository: %v", err)
+	}
+
+	return &LocalRepository{Type: "unknown", Repository: r}
+}
+
+// gitCommand runs git in the current directory and fails the test on errors
+func gitCommand(t *testing.T, arg ...string) string {
+	t.Helper()
+
+	output, err := exec.Command("git", arg...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(arg, " "), err, output)
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+// commitFile writes content to a file and commits it
+func commitFile(t *testing.T, filename, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(filename, []byte(content+"\n"), 0o644); err != nil {
+		t.Fatalf("writing %s: %v", filename, err)
+	}
+	gitCommand(t, "add", filename)
+	gitCommand(t, "commit", "-q", "-m", "Change "+filename)
+}
+
+// newTestRemote creates a bare repository, adds it as origin and pushes main and the given branches to it
+func newTestRemote(t *testing.T, branchNames ...string) string {
+	t.Helper()
+
+	remoteDir := filepath.Join(t.TempDir(), "remote.git")
+	gitCommand(t, "init", "-q", "--bare", remoteDir)
+	gitCommand(t, "remote", "add", "origin", remoteDir)
+	gitCommand(t, append([]string{"push", "-q", "origin", "main"}, branchNames...)...)
+	gitCommand(t, "remote", "set-head", "origin", "main")
+
+	return remoteDir
+}
+
+// useRemoteConfig points remote.base at upstream and remote.features at origin for the duration of the test
+func useRemoteConfig(t *testing.T) {
+	t.Helper()
+
+	previousConfig := core.Config
+	t.Cleanup(func() { core.Config = previousConfig })
+	core.Config = viper.New()
+	core.Config.Set("remote.base", "upstream")
+	core.Config.Set("remote.features", "origin")
+}
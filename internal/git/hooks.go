@@ -11,19 +11,23 @@ import (
 const (
 	PILA_HOOK_DIRECTORY            = ".pila.hooks.d"
 	PILA_HOOK_MULTI_MERGE_COMPLETE = "multi-merge-completed.sh"
+	PILA_HOOK_MULTI_MERGE_FAILED   = "multi-merge-failed.sh"
 )
 
 func (r *LocalRepository) RunHook(hookFile string, arg ...string) error {
 
-	hookFile, err := filepath.Abs(filepath.Join(PILA_HOOK_DIRECTORY, hookFile))
+	// Hooks live in, and are run from, the top level of the worktree, even when pila is started in a subdirectory
+	topLevel, err := r.TopLevel()
 	if err != nil {
 		return err
 	}
+	hookFile = filepath.Join(topLevel, PILA_HOOK_DIRECTORY, hookFile)
 
 	_, err = os.Stat(hookFile)
 	if err == nil {
-		r.Note("Running hook after all merges completed successfully")
+		r.Note("Running hook %s", filepath.Base(hookFile))
 		cmd := exec.Command(hookFile, arg...)
+		cmd.Dir = topLevel
 		output, err := cmd.Output()
 		if err != nil {
 			return err
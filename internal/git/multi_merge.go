@@ -4,12 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"slices"
 	"strings"
-)
 
-const (
-	MULTI_MERGE_TYPE_BRANCHES = "branches"
-	MULTI_MERGE_TYPE_LABELS   = "labels"
+	"github.com/fatih/color"
 )
 
 type MultiMergeDoneError struct{}
@@ -18,56 +17,234 @@ type MultiMergeDoneError struct{}
 type MultiMergeConflictError struct {
 	BranchName string
 	Manifest   *MultiMergeManifest
+	WorkDir    string // Worktree the conflict has to be resolved in, empty for the current checkout
 }
 
 func (e *MultiMergeConflictError) Error() string {
 	return fmt.Sprintf("merge conflict occurred while merging branch '%s'", e.BranchName)
 }
 
+// MultiMergeVerifyError is returned when the verify command fails after merging a reference during multi-merge
+type MultiMergeVerifyError struct {
+	BranchName string
+	Command    string
+	Output     string // Combined output of the command
+	Manifest   *MultiMergeManifest
+	WorkDir    string // Worktree the failure has to be fixed in, empty for the current checkout
+}
+
+func (e *MultiMergeVerifyError) Error() string {
+	return fmt.Sprintf("'%s' failed after merging branch '%s'", e.Command, e.BranchName)
+}
+
 // LocalOnlyBranchesError is returned when branches exist only locally
 type LocalOnlyBranchesError struct {
+	Remote      string // Remote the branches are missing from
 	BranchNames []string
 }
 
 func (e *LocalOnlyBranchesError) Error() string {
-	return fmt.Sprintf("branches exist only locally: %s", strings.Join(e.BranchNames, ", "))
+	return fmt.Sprintf("branches exist only locally, not on %s: %s", e.Remote, strings.Join(e.BranchNames, ", "))
 }
 
-func (r *LocalRepository) MultiMergeNamedBranches(target string, branchNames []string) (*MultiMergeManifest, error) {
-	// Make sure we have all changes
-	r.Note("Make sure we have all changes")
-	fetchOutput, err := r.ExecuteGitCommand("fetch")
+// DefaultMultiMergeOptions returns the multi-merge options set in the config, under the multi_merge key
+func DefaultMultiMergeOptions() MultiMergeOptions {
+	return MultiMergeOptions{
+		Worktree:   configBool("multi_merge.worktree", false),
+		OnConflict: configString("multi_merge.on_conflict", ""),
+		Verify:     configString("multi_merge.verify", ""),
+	}
+}
+
+// Validate checks the options of a multi-merge
+func (o MultiMergeOptions) Validate() error {
+	if !slices.Contains([]string{"", MULTI_MERGE_ON_CONFLICT_STOP, MULTI_MERGE_ON_CONFLICT_SKIP}, o.OnConflict) {
+		return fmt.Errorf("unknown on_conflict setting '%s', use %s or %s", o.OnConflict, MULTI_MERGE_ON_CONFLICT_STOP, MULTI_MERGE_ON_CONFLICT_SKIP)
+	}
+
+	return nil
+}
+
+func (r *LocalRepository) MultiMergeNamedBranches(target string, branchNames []string, options MultiMergeOptions) (*MultiMergeManifest, error) {
+	references := []MultiMergeReference{}
+	for _, branchName := range branchNames {
+		references = append(references, MultiMergeReference{
+			Name: branchName,
+		})
+	}
+
+	return r.MultiMergeReferences(target, references, options)
+}
+
+// Multi merge branches, each with its own merge options
+func (r *LocalRepository) MultiMergeReferences(target string, references []MultiMergeReference, options MultiMergeOptions) (*MultiMergeManifest, error) {
+	multiMergeManifest := &MultiMergeManifest{
+		Target:     target,
+		Type:       MULTI_MERGE_MANIFEST_TYPE_BRANCHES,
+		Options:    options,
+		References: references,
+	}
+
+	return r.multiMergeStart(multiMergeManifest)
+}
+
+// Multi merge using named labels from merge requests
+func (r *LocalRepository) MultiMergeNamedLabels(target string, labels []string, options MultiMergeOptions) (*MultiMergeManifest, error) {
+	multiMergeManifest := &MultiMergeManifest{
+		Target:  target,
+		Type:    MULTI_MERGE_MANIFEST_TYPE_LABELS,
+		Labels:  labels,
+		Options: options,
+	}
+
+	references, err := r.labeledReferences(multiMergeManifest)
+	if err != nil {
+		return nil, err
+	}
+	multiMergeManifest.References = references
+
+	return r.multiMergeStart(multiMergeManifest)
+}
+
+// labeledReferences finds the open merge requests carrying the labels of the manifest, on the forge its base
+// remote is hosted on, and turns them into references
+func (r *LocalRepository) labeledReferences(manifest *MultiMergeManifest) ([]MultiMergeReference, error) {
+	labels := manifest.Labels
+	r.Note("Find merge requests labeled %s", strings.Join(labels, ", "))
+	baseRemote, featuresRemote := r.multiMergeRemotes(manifest)
+	forge, err := r.RemoteForge(baseRemote)
+	if err != nil {
+		return nil, err
+	}
+	mergeRequests, err := forge.MergeRequestsWithLabels(labels)
 	if err != nil {
 		return nil, err
 	}
+	if len(mergeRequests) == 0 {
+		return nil, fmt.Errorf("no open merge requests labeled %s", strings.Join(labels, ", "))
+	}
+
+	references := []MultiMergeReference{}
+	for _, mergeRequest := range mergeRequests {
+		reference := MultiMergeReference{
+			Name:         mergeRequest.SourceBranch,
+			Number:       mergeRequest.Number,
+			URL:          mergeRequest.URL,
+			SourceBranch: mergeRequest.SourceBranch,
+		}
+
+		// Branches from forks are fetched into <features remote>/<ref name>, e.g. origin/pull/12, keeping the name
+		// of the branch in SourceBranch, and branches of the base remote into <features remote>/<branch name>, as
+		// that is where branches are looked up
+		if mergeRequest.Ref != "" {
+			reference.Name = strings.TrimSuffix(strings.TrimPrefix(mergeRequest.Ref, "refs/"), "/head")
+			reference.Ref = mergeRequest.Ref
+		} else if baseRemote != featuresRemote {
+			reference.Ref = "refs/heads/" + mergeRequest.SourceBranch
+		}
+
+		fmt.Printf("%s %s %s\n", color.CyanString(reference.Name), mergeRequest.Title, color.HiBlackString(mergeRequest.URL))
+		references = append(references, reference)
+	}
+
+	return references, nil
+}
+
+// multiMergeRemotes returns the base and features remotes of a manifest, taken from the config unless the
+// manifest overrides them
+func (r *LocalRepository) multiMergeRemotes(manifest *MultiMergeManifest) (string, string) {
+	baseRemote := manifest.Options.Remotes.Base
+	if baseRemote == "" {
+		baseRemote = r.BaseRemote()
+	}
+	featuresRemote := manifest.Options.Remotes.Features
+	if featuresRemote == "" {
+		featuresRemote = r.FeaturesRemote()
+	}
+
+	return baseRemote, featuresRemote
+}
+
+// fetchMultiMergeRemotes fetches the base and features remotes of a manifest, passing any extra arguments on to git fetch
+func (r *LocalRepository) fetchMultiMergeRemotes(manifest *MultiMergeManifest, arg ...string) error {
+	r.Note("Make sure we have all changes")
+
+	fetchArgs := append([]string{"fetch"}, arg...)
+	baseRemote, featuresRemote := r.multiMergeRemotes(manifest)
+	if baseRemote == featuresRemote {
+		fetchArgs = append(fetchArgs, baseRemote)
+	} else {
+		fetchArgs = append(fetchArgs, "--multiple", baseRemote, featuresRemote)
+	}
+
+	fetchOutput, err := r.ExecuteGitCommand(fetchArgs...)
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(fetchOutput))
+	}
 	if fetchOutput != "" {
 		fmt.Println(fetchOutput)
 	}
 
-	// Checkout main branch
-	mainBranchName, err := r.MainBranchName()
-	if err != nil {
+	return nil
+}
+
+// fetchReferenceRefs fetches the refs of merge requests off of the base remote, where the merge requests are
+// opened, into the features remote namespace, where branches to merge are looked up
+func (r *LocalRepository) fetchReferenceRefs(manifest *MultiMergeManifest) error {
+	baseRemote, featuresRemote := r.multiMergeRemotes(manifest)
+	for _, reference := range manifest.References {
+		if reference.Ref == "" {
+			continue
+		}
+
+		output, err := r.ExecuteGitCommand("fetch", baseRemote, fmt.Sprintf("+%s:refs/remotes/%s/%s", reference.Ref, featuresRemote, reference.Name))
+		if err != nil {
+			return fmt.Errorf("fetching %s: %s", reference.Ref, output)
+		}
+		if output != "" {
+			fmt.Println(output)
+		}
+	}
+
+	return nil
+}
+
+// multiMergeStart recreates the target branch off of main and merges all references in the manifest
+func (r *LocalRepository) multiMergeStart(multiMergeManifest *MultiMergeManifest) (*MultiMergeManifest, error) {
+	target := multiMergeManifest.Target
+	if err := multiMergeManifest.Options.Validate(); err != nil {
 		return nil, err
 	}
 
-	// Create todo list
-	mainSha, err := r.ExecuteGitCommandQuiet("rev-parse", "--verify", mainBranchName)
+	// Leave the current checkout alone, and merge in a worktree of its own
+	if multiMergeManifest.Options.Worktree && r.WorkDir == "" {
+		if err := r.enterMultiMergeWorktree(target); err != nil {
+			return nil, err
+		}
+	}
+	multiMergeManifest.dir = r.WorkDir
+
+	// Make sure we have all changes
+	if err := r.fetchMultiMergeRemotes(multiMergeManifest); err != nil {
+		return nil, err
+	}
+	if err := r.fetchReferenceRefs(multiMergeManifest); err != nil {
+		return nil, err
+	}
+
+	// Find the branch to build the target on
+	baseBranchName, err := r.multiMergeBaseBranch(multiMergeManifest)
 	if err != nil {
 		return nil, err
 	}
-	multiMergeManifest := &MultiMergeManifest{
-		MainSha:    mainSha,
-		Target:     target,
-		Type:       MULTI_MERGE_TYPE_BRANCHES,
-		References: []MultiMergeReference{},
+
+	// Record where the base was when we started, so the target can be rebuilt on the same commit
+	mainSha, err := r.GetSha(baseBranchName)
+	if err != nil {
+		return nil, err
 	}
 	multiMergeManifest.MainSha = mainSha
 
-	for _, branchName := range branchNames {
-		multiMergeManifest.References = append(multiMergeManifest.References, MultiMergeReference{
-			Name: branchName,
-		})
-	}
 	// Delete any existing manifest, to prevent it from interfering with the creation/checkout of the target branch
 	multiMergeManifest.Remove()
 
@@ -75,23 +252,27 @@ func (r *LocalRepository) MultiMergeNamedBranches(target string, branchNames []s
 	_, err = r.ExecuteGitCommandQuiet("rev-parse", "--verify", target)
 	if err == nil {
 		r.Note("Checkout target branch")
-		r.ExecuteGitCommand("checkout", target)
+		if output, err := r.ExecuteGitCommand("checkout", target); err != nil {
+			return multiMergeManifest, fmt.Errorf("%s", strings.TrimSpace(output))
+		}
 
-		r.Note("Make target branch point to %s", mainBranchName)
-		_, err = r.ExecuteGitCommand("reset", "--hard", fmt.Sprintf("origin/%s", mainBranchName))
+		r.Note("Make target branch point to %s", baseBranchName)
+		_, err = r.ExecuteGitCommand("reset", "--hard", mainSha)
 		if err != nil {
 			return multiMergeManifest, err
 		}
 	} else {
 		r.Note("Create target branch")
-		r.ExecuteGitCommand("checkout", "-b", target)
+		if output, err := r.ExecuteGitCommand("checkout", "-b", target, mainSha); err != nil {
+			return multiMergeManifest, fmt.Errorf("%s", strings.TrimSpace(output))
+		}
 	}
 
 	// Save current manifest after creation of target branch
 	multiMergeManifest.Save()
 
 	for {
-		multiMergeManifest, err := r.MultiMergeNamedContinue()
+		multiMergeManifest, err = r.MultiMergeNamedContinue()
 		if err != nil {
 			return multiMergeManifest, err
 		}
@@ -104,51 +285,78 @@ func (r *LocalRepository) MultiMergeNamedBranches(target string, branchNames []s
 	return multiMergeManifest, nil
 }
 
-// Load existing Manifest and redo merges
-func (r *LocalRepository) MultiMergeUsingManifest() error {
+// Load existing Manifest and redo merges. When frozen, the target is rebuilt from the exact commits recorded
+// in the manifest, instead of the current main branch and branches.
+func (r *LocalRepository) MultiMergeUsingManifest(frozen bool) error {
 	// Load & reset manifest
-	manifest, err := LoadMultiMergeManifest()
+	manifest, err := r.LoadMultiMergeManifest()
 	if err != nil {
 		return err
 	}
+	if frozen {
+		if err := r.checkFrozenManifest(manifest); err != nil {
+			return err
+		}
+	}
 	manifest.Reset()
 
 	// Make sure we have all changes
-	r.Note("Make sure we have all changes")
-	fetchOutput, err := r.ExecuteGitCommand("fetch")
-	if err != nil {
+	if err := r.fetchMultiMergeRemotes(manifest); err != nil {
 		return err
 	}
-	if fetchOutput != "" {
-		fmt.Println(fetchOutput)
-	}
 
-	// Check for local-only branches before making changes
-	branchNames := make([]string, len(manifest.References))
-	for i, ref := range manifest.References {
-		branchNames[i] = ref.Name
+	// Pick up merge requests that have been labeled or unlabeled since last time
+	if manifest.Type == MULTI_MERGE_MANIFEST_TYPE_LABELS && !frozen {
+		manifest.References, err = r.labeledReferences(manifest)
+		if err != nil {
+			return err
+		}
+	}
+	if err := r.fetchReferenceRefs(manifest); err != nil {
+		return err
 	}
 
-	localOnlyBranches, err := r.CheckBranchesHaveRemotes(branchNames)
+	baseBranchName, err := r.multiMergeBaseBranch(manifest)
 	if err != nil {
 		return err
 	}
 
-	if len(localOnlyBranches) > 0 {
-		return &LocalOnlyBranchesError{BranchNames: localOnlyBranches}
+	if !frozen {
+		// Check for local-only branches before making changes
+		branchNames := make([]string, len(manifest.References))
+		for i, ref := range manifest.References {
+			branchNames[i] = ref.Name
+		}
+
+		_, featuresRemote := r.multiMergeRemotes(manifest)
+		localOnlyBranches, err := r.CheckBranchesHaveRemotes(featuresRemote, branchNames)
+		if err != nil {
+			return err
+		}
+
+		if len(localOnlyBranches) > 0 {
+			return &LocalOnlyBranchesError{Remote: featuresRemote, BranchNames: localOnlyBranches}
+		}
+
+		// Merge the branches as they are now, on top of the base as it is now
+		manifest.MainSha, err = r.GetSha(baseBranchName)
+		if err != nil {
+			return err
+		}
+		for i := range manifest.References {
+			manifest.References[i].Sha = ""
+		}
 	}
 
-	// Reset target branch
+	// Reset target branch, making sure it is checked out first, so the hard reset does not hit another branch
 	r.Note("Checkout target branch")
-	r.ExecuteGitCommand("checkout", manifest.Target)
-
-	// Reset target branch to main
-	mainBranchName, err := r.MainBranchName()
-	if err != nil {
-		return err
+	if output, err := r.ExecuteGitCommand("checkout", manifest.Target); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(output))
 	}
-	r.Note("Make target branch point to %s", mainBranchName)
-	_, err = r.ExecuteGitCommand("reset", "--hard", fmt.Sprintf("origin/%s", mainBranchName))
+
+	// Reset target branch to the base
+	r.Note("Make target branch point to %s at %.7s", baseBranchName, manifest.MainSha)
+	_, err = r.ExecuteGitCommand("reset", "--hard", manifest.MainSha)
 	if err != nil {
 		return err
 	}
@@ -171,14 +379,103 @@ func (r *LocalRepository) MultiMergeUsingManifest() error {
 	return nil
 }
 
-// Multi merge using named labels from PRs
-func (r *LocalRepository) MultiMergeNamedLabels(target string, labels []string) error {
-	return errors.New("multi merge using PR labels not implemented yet")
+// multiMergeBaseBranch returns the remote branch the target of a manifest is built on, <base remote>/<main>
+// unless the manifest has a base of its own
+func (r *LocalRepository) multiMergeBaseBranch(manifest *MultiMergeManifest) (string, error) {
+	baseRemote, _ := r.multiMergeRemotes(manifest)
+	baseBranchName := manifest.Options.Base
+	if baseBranchName == "" {
+		mainBranchName, err := r.remoteMainBranchName(baseRemote)
+		if err != nil {
+			return "", err
+		}
+		baseBranchName = mainBranchName
+	}
+
+	return fmt.Sprintf("%s/%s", baseRemote, baseBranchName), nil
+}
+
+// checkFrozenManifest makes sure the main branch and every reference in the manifest has a recorded commit
+// that is still available, so the target can be rebuilt exactly
+func (r *LocalRepository) checkFrozenManifest(manifest *MultiMergeManifest) error {
+	if manifest.MainSha == "" {
+		return errors.New("the manifest has no recorded main sha, please run 'pila multi-merge redo' without --frozen")
+	}
+	if _, err := r.GetSha(manifest.MainSha + "^{commit}"); err != nil {
+		return err
+	}
+
+	for _, reference := range manifest.References {
+		if reference.Sha == "" {
+			return fmt.Errorf("%s has no recorded sha, please run 'pila multi-merge redo' without --frozen", reference.Name)
+		}
+		if _, err := r.GetSha(reference.Sha + "^{commit}"); err != nil {
+			return fmt.Errorf("%s: %w", reference.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// ReferenceSha returns the commit a reference of the manifest would be merged at now, or an empty string when
+// the branch is gone
+func (r *LocalRepository) ReferenceSha(manifest *MultiMergeManifest, name string) string {
+	_, featuresRemote := r.multiMergeRemotes(manifest)
+	_, sha, _ := r.resolveReferenceBranch(featuresRemote, name)
+
+	return sha
+}
+
+// resolveReferenceBranch finds the branch to merge for a reference, <remote>/<name> preferred over the local
+// branch, and the commit it points to. Both are empty when no branch has the name at all.
+func (r *LocalRepository) resolveReferenceBranch(remote, name string) (string, string, error) {
+	// Get list of heads matching the branch we want to merge
+	heads, err := r.NamedBranches(name)
+	if err != nil {
+		return "", "", nil
+	}
+
+	remoteBranchName := fmt.Sprintf("%s/%s", remote, name)
+	if sha, exists := heads[remoteBranchName]; exists {
+		return remoteBranchName, sha, nil
+	} else if sha, exists := heads[name]; exists {
+		return name, sha, nil
+	}
+
+	return "", "", fmt.Errorf("unable to find a branch named '%s' on %s or locally", name, remote)
+}
+
+// multiMergeCommitish returns what to merge for a reference, the recorded commit when there is one, otherwise
+// the branch on the features remote or the local branch, whose commit is then recorded. It is empty when the
+// branch does not exist.
+func (r *LocalRepository) multiMergeCommitish(featuresRemote string, reference *MultiMergeReference) (string, error) {
+	if reference.Sha != "" {
+		return reference.Sha, nil
+	}
+
+	branchNameToMerge, sha, err := r.resolveReferenceBranch(featuresRemote, reference.Name)
+	if err != nil || branchNameToMerge == "" {
+		return "", err
+	}
+	reference.Sha = sha
+
+	return branchNameToMerge, nil
+}
+
+// commitSquash commits the staged result of squash merging a reference
+func (r *LocalRepository) commitSquash(reference *MultiMergeReference, target string) error {
+	r.Note("Commit squashed branch %s", reference.Name)
+	commitOutput, err := r.ExecuteGitCommand("commit", "-m", fmt.Sprintf("Squash branch '%s' at %.7s into %s", reference.Name, reference.Sha, target))
+	if commitOutput != "" {
+		fmt.Println(commitOutput)
+	}
+
+	return err
 }
 
 // Process the rest of the todo list
 func (r *LocalRepository) MultiMergeNamedContinue() (*MultiMergeManifest, error) {
-	manifest, err := LoadMultiMergeManifest()
+	manifest, err := r.LoadMultiMergeManifest()
 	if err != nil {
 		return nil, err
 	}
@@ -186,7 +483,21 @@ func (r *LocalRepository) MultiMergeNamedContinue() (*MultiMergeManifest, error)
 	// Find first merge that isn't merged yet
 	for i := 0; i < len(manifest.References); i++ {
 		reference := &manifest.References[i]
-		if reference.Merged {
+
+		// Check the merge again, the target branch may have been fixed since the verify command failed
+		if reference.Merged && reference.VerifyFailed {
+			groupSize := 1
+			for i+groupSize < len(manifest.References) && manifest.References[i+groupSize].VerifyFailed {
+				groupSize++
+			}
+			if err := r.verifyMerge(manifest, i, groupSize); err != nil {
+				return manifest, err
+			}
+			i = i + groupSize - 1
+			continue
+		}
+
+		if reference.Merged || reference.Skipped {
 			// If this is the last branch and is has been merged return with success
 			if i == len(manifest.References)-1 {
 				r.Note("Last has been merged")
@@ -195,9 +506,18 @@ func (r *LocalRepository) MultiMergeNamedContinue() (*MultiMergeManifest, error)
 			continue
 		}
 
+		if err := reference.Options.Validate(); err != nil {
+			return manifest, fmt.Errorf("%s: %w", reference.Name, err)
+		}
+		groupSize := manifest.mergeGroupSize(i)
+
 		// Figure out if this is an ongoing merge, or just the next unmerged branch
 		if branchName, err := r.OngoingMergeBranchName(); err == nil && branchName != "" {
-			commitMessageBytes, err := os.ReadFile(".git/MERGE_MSG")
+			mergeMessagePath, err := r.GitPath("MERGE_MSG")
+			if err != nil {
+				return manifest, err
+			}
+			commitMessageBytes, err := os.ReadFile(mergeMessagePath)
 			if err != nil {
 				return manifest, err
 			}
@@ -212,61 +532,221 @@ func (r *LocalRepository) MultiMergeNamedContinue() (*MultiMergeManifest, error)
 			if commitOutput != "" {
 				fmt.Println(commitOutput)
 			}
+			for j := i; j < i+groupSize; j++ {
+				manifest.References[j].Merged = true
+			}
+			manifest.Save()
+			if err := r.verifyMerge(manifest, i, groupSize); err != nil {
+				return manifest, err
+			}
+			i = i + groupSize - 1
+		} else if reference.Options.Squash && r.SquashInProgress() {
+			if err := r.commitSquash(reference, manifest.Target); err != nil {
+				return manifest, err
+			}
 			reference.Merged = true
 			manifest.Save()
+			if err := r.verifyMerge(manifest, i, 1); err != nil {
+				return manifest, err
+			}
 		} else {
-			r.Note("Merge branch %s into %s", reference.Name, manifest.Target)
+			// Find what to merge for every reference in the group, dropping branches that no longer exist
+			_, featuresRemote := r.multiMergeRemotes(manifest)
+			mergeArgs := append([]string{"merge"}, reference.Options.mergeArgs()...)
+			mergeNames := []string{}
+			frozenNames := []string{}
+			for j := i; j < i+groupSize; j++ {
+				member := &manifest.References[j]
+				commitish, err := r.multiMergeCommitish(featuresRemote, member)
+				if err != nil {
+					return manifest, err
+				}
+				if commitish == "" {
+					r.Warn("Branch %s does not exist, skipping", member.Name)
+					manifest.References = append(manifest.References[:j], manifest.References[j+1:]...)
+					j = j - 1
+					groupSize = groupSize - 1
+					continue
+				}
 
-			// Get list of heads matching the branch we want to merge
-			heads, err := r.NamedBranches(reference.Name)
-			if err != nil {
-				r.Warn("Branch %s does not exist, skipping", reference.Name)
-				manifest.References = append(manifest.References[:i], manifest.References[i+1:]...)
+				// Recorded commits are merged by sha, the branch may have moved on or be gone by now
+				if commitish == member.Sha {
+					frozenNames = append(frozenNames, fmt.Sprintf("'%s' at %.7s", member.Name, member.Sha))
+				}
+				mergeNames = append(mergeNames, member.Name)
+				mergeArgs = append(mergeArgs, commitish)
+			}
+
+			// Record the commits being merged, before a conflict can interrupt us
+			manifest.Save()
+			if groupSize == 0 {
 				i = i - 1
-				manifest.Save()
 				continue
 			}
+			reference = &manifest.References[i]
 
-			// Figure out which branch to merge local or remote (local preferred)
-			branchNameToMerge := ""
-			if _, exists := heads[fmt.Sprintf("origin/%s", reference.Name)]; exists {
-				branchNameToMerge = fmt.Sprintf("origin/%s", reference.Name)
-			} else if _, exists := heads[reference.Name]; exists {
-				branchNameToMerge = reference.Name
-			} else {
-				return manifest, fmt.Errorf("unable to find a branch named '%s'", reference.Name)
+			if len(frozenNames) == groupSize && !reference.Options.Squash {
+				branchWord := "branch"
+				if groupSize > 1 {
+					branchWord = "branches"
+				}
+				mergeMessage := fmt.Sprintf("Merge %s %s into %s", branchWord, strings.Join(frozenNames, ", "), manifest.Target)
+				mergeArgs = slices.Insert(mergeArgs, 1, "-m", mergeMessage)
 			}
 
-			mergeOutput, err := r.ExecuteGitCommand("merge", branchNameToMerge)
+			r.Note("Merge branch %s into %s", strings.Join(mergeNames, ", "), manifest.Target)
+			mergeOutput, err := r.ExecuteGitCommand(mergeArgs...)
 			if mergeOutput != "" {
 				fmt.Println(mergeOutput)
 			}
 			if err != nil {
-				// Check if this is a merge conflict by checking if MERGE_HEAD exists
-				if _, statErr := os.Stat(".git/MERGE_HEAD"); statErr == nil {
+				// Check if this is a merge conflict by checking if MERGE_HEAD exists, or the squash is pending
+				if r.MergeInProgress() || (reference.Options.Squash && r.SquashInProgress()) {
+					if manifest.Options.OnConflict == MULTI_MERGE_ON_CONFLICT_SKIP {
+						if err := r.skipConflictingMerge(manifest, i, groupSize); err != nil {
+							return manifest, err
+						}
+						i = i + groupSize - 1
+						continue
+					}
+
 					return manifest, &MultiMergeConflictError{
-						BranchName: branchNameToMerge,
+						BranchName: strings.Join(mergeNames, ", "),
 						Manifest:   manifest,
+						WorkDir:    r.WorkDir,
 					}
 				}
 				return manifest, err
 			}
-			reference.Merged = true
+
+			// Squash merges stop before committing
+			if reference.Options.Squash && r.SquashInProgress() {
+				if err := r.commitSquash(reference, manifest.Target); err != nil {
+					return manifest, err
+				}
+			}
+
+			for j := i; j < i+groupSize; j++ {
+				manifest.References[j].Merged = true
+			}
 			manifest.Save()
+			if err := r.verifyMerge(manifest, i, groupSize); err != nil {
+				return manifest, err
+			}
+			i = i + groupSize - 1
 		}
 	}
 
 	if manifest.IsDone() {
+		skippedNames := []string{}
+		for _, reference := range manifest.SkippedReferences() {
+			skippedNames = append(skippedNames, reference.Name)
+		}
+		if len(skippedNames) > 0 {
+			r.printSkippedSummary(manifest)
+		}
+
 		r.MultiMergeCommitManifest()
-		r.RunHook(PILA_HOOK_MULTI_MERGE_COMPLETE, manifest.Target)
+		r.RunHook(PILA_HOOK_MULTI_MERGE_COMPLETE, append([]string{manifest.Target}, skippedNames...)...)
 	}
 
 	return manifest, nil
 }
 
+// verifyMerge runs the verify command of the manifest after merging a group of references, if there is one.
+// When it fails the references are marked, so continue runs it again, and a MultiMergeVerifyError is returned.
+func (r *LocalRepository) verifyMerge(manifest *MultiMergeManifest, index, groupSize int) error {
+	command := manifest.Options.Verify
+	if command == "" {
+		return nil
+	}
+
+	names := []string{}
+	for j := index; j < index+groupSize; j++ {
+		names = append(names, manifest.References[j].Name)
+	}
+
+	// Run from the top level of the worktree, even when pila is started in a subdirectory
+	topLevel, err := r.TopLevel()
+	if err != nil {
+		return err
+	}
+
+	r.Note("Verify %s with '%s'", strings.Join(names, ", "), command)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = topLevel
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		fmt.Println(strings.TrimSpace(string(output)))
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return err
+	}
+	for j := index; j < index+groupSize; j++ {
+		manifest.References[j].VerifyFailed = err != nil
+	}
+	if saveErr := manifest.Save(); saveErr != nil {
+		return saveErr
+	}
+	if err != nil {
+		return &MultiMergeVerifyError{
+			BranchName: strings.Join(names, ", "),
+			Command:    command,
+			Output:     strings.TrimSpace(string(output)),
+			Manifest:   manifest,
+			WorkDir:    r.WorkDir,
+		}
+	}
+
+	return nil
+}
+
+// skipConflictingMerge backs out of the conflicting merge of a group of references, and marks them as skipped
+// with the files that conflicted
+func (r *LocalRepository) skipConflictingMerge(manifest *MultiMergeManifest, index, groupSize int) error {
+	conflictingFiles := []string{}
+	filesOutput, err := r.ExecuteGitCommandQuiet("diff", "--name-only", "--diff-filter=U")
+	if err == nil && filesOutput != "" {
+		conflictingFiles = strings.Split(filesOutput, "\n")
+	}
+
+	// Squash merges leave no MERGE_HEAD to abort, and do not move HEAD either
+	abortArgs := []string{"merge", "--abort"}
+	if !r.MergeInProgress() {
+		abortArgs = []string{"reset", "--hard", "HEAD"}
+	}
+	r.Note("Skip conflicting merge")
+	if output, err := r.ExecuteGitCommand(abortArgs...); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	for j := index; j < index+groupSize; j++ {
+		reference := &manifest.References[j]
+		reference.Skipped = true
+		reference.ConflictingFiles = conflictingFiles
+		r.Warn("Skipped %s, it conflicts in %s", reference.Name, strings.Join(conflictingFiles, ", "))
+	}
+
+	return manifest.Save()
+}
+
+// printSkippedSummary lists the references left out of the target because they conflicted
+func (r *LocalRepository) printSkippedSummary(manifest *MultiMergeManifest) {
+	skipped := manifest.SkippedReferences()
+	r.Warn("%d of %d branches were skipped because they conflict", len(skipped), len(manifest.References))
+	for _, reference := range skipped {
+		fmt.Printf("%s\n", color.YellowString(reference.Name))
+		for _, filename := range reference.ConflictingFiles {
+			fmt.Printf("  %s\n", filename)
+		}
+	}
+}
+
 func (r *LocalRepository) MultiMergeAbort() error {
 	// Load the manifest at the start so we can restore it after reset
-	manifest, err := LoadMultiMergeManifest()
+	manifest, err := r.LoadMultiMergeManifest()
 	if err != nil {
 		return err
 	}
@@ -288,12 +768,12 @@ func (r *LocalRepository) MultiMergeAbort() error {
 		return err
 	}
 
-	// Reset target branch to main
-	mainBranchName, err := r.MainBranchName()
+	// Reset target branch to the base
+	baseBranchName, err := r.multiMergeBaseBranch(manifest)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	output, err := r.ExecuteGitCommand("reset", "--hard", fmt.Sprintf("origin/%s", mainBranchName))
+	output, err := r.ExecuteGitCommand("reset", "--hard", baseBranchName)
 	fmt.Println(output)
 	if err != nil {
 		return err
@@ -318,52 +798,34 @@ type MultiMergeTestResult struct {
 	BranchResults []MultiMergeTestBranchResult `json:"branches"`
 }
 
+// MultiMergeTest simulates the multi-merge in memory, without touching HEAD, the index or the working tree.
+//
+// Each merge is computed with `git merge-tree --write-tree`, and clean merges are committed as dangling
+// commits with `git commit-tree`, so the next branch is merged on top of the previous ones. After the first
+// conflict the remaining branches are tested against the base alone. Octopus groups are tested one branch at a time.
 func (r *LocalRepository) MultiMergeTest() (*MultiMergeTestResult, error) {
-	manifest, err := LoadMultiMergeManifest()
-	if err != nil {
-		return nil, err
-	}
-
-	// Fetch latest changes
-	r.Note("Make sure we have all changes")
-	fetchOutput, err := r.ExecuteGitCommand("fetch")
-	if err != nil {
-		return nil, err
-	}
-	if fetchOutput != "" {
-		fmt.Println(fetchOutput)
-	}
-
-	// Save current branch name
-	originalBranch, err := r.ExecuteGitCommandQuiet("rev-parse", "--abbrev-ref", "HEAD")
+	manifest, err := r.LoadMultiMergeManifest()
 	if err != nil {
 		return nil, err
 	}
 
-	mainBranchName, err := r.MainBranchName()
+	baseSha, err := r.fetchMultiMergeBase(manifest)
 	if err != nil {
 		return nil, err
 	}
 
-	// Checkout detached HEAD at origin/<main>
-	r.Note("Checkout detached HEAD at origin/%s", mainBranchName)
-	_, err = r.ExecuteGitCommand("checkout", "--detach", fmt.Sprintf("origin/%s", mainBranchName))
-	if err != nil {
-		// Restore original branch before returning
-		r.ExecuteGitCommandQuiet("checkout", originalBranch)
-		return nil, err
-	}
-
 	result := &MultiMergeTestResult{
 		OK:            true,
 		BranchResults: []MultiMergeTestBranchResult{},
 	}
 	sequential := true
+	mergedSha := baseSha
+	_, featuresRemote := r.multiMergeRemotes(manifest)
 
 	for _, reference := range manifest.References {
-		// Resolve branch name (prefer origin/<branch> over local)
-		heads, err := r.NamedBranches(reference.Name)
-		if err != nil {
+		// Resolve branch name (prefer the features remote over local)
+		branchNameToMerge, _, err := r.resolveReferenceBranch(featuresRemote, reference.Name)
+		if err != nil || branchNameToMerge == "" {
 			result.BranchResults = append(result.BranchResults, MultiMergeTestBranchResult{
 				Name:   reference.Name,
 				Status: "missing",
@@ -371,54 +833,29 @@ func (r *LocalRepository) MultiMergeTest() (*MultiMergeTestResult, error) {
 			continue
 		}
 
-		branchNameToMerge := ""
-		if _, exists := heads[fmt.Sprintf("origin/%s", reference.Name)]; exists {
-			branchNameToMerge = fmt.Sprintf("origin/%s", reference.Name)
-		} else if _, exists := heads[reference.Name]; exists {
-			branchNameToMerge = reference.Name
-		} else {
-			result.BranchResults = append(result.BranchResults, MultiMergeTestBranchResult{
-				Name:   reference.Name,
-				Status: "missing",
-			})
-			continue
-		}
-
-		// If a prior branch conflicted, reset to main for a clean "branch vs main" test
-		if !sequential {
-			r.ExecuteGitCommandQuiet("reset", "--hard", fmt.Sprintf("origin/%s", mainBranchName))
-		}
-
+		// If a prior branch conflicted, test against the base alone
 		mergeType := "sequential"
+		ours := mergedSha
 		if !sequential {
 			mergeType = "main-only"
+			ours = baseSha
 		}
 
 		r.Note("Test merge %s (%s)", reference.Name, mergeType)
-		mergeOutput, mergeErr := r.ExecuteGitCommand("merge", "--no-ff", "--no-commit", branchNameToMerge)
+		tree, conflictingFiles, mergeErr := r.mergeTree(ours, branchNameToMerge, reference.Options)
 
-		if mergeErr == nil {
-			// Clean merge
-			if sequential {
-				// Commit to advance the base for subsequent branches
-				r.ExecuteGitCommandQuiet("commit", "-m", fmt.Sprintf("test merge %s", reference.Name))
-			} else {
-				r.ExecuteGitCommandQuiet("merge", "--abort")
-			}
+		if mergeErr != nil {
+			r.Err("merge error for %s: %s", reference.Name, mergeErr)
+
+			sequential = false
+			result.OK = false
 			result.BranchResults = append(result.BranchResults, MultiMergeTestBranchResult{
 				Name:      reference.Name,
-				Status:    "clean",
+				Status:    "error",
 				MergeType: mergeType,
+				Error:     mergeErr.Error(),
 			})
-		} else if _, statErr := os.Stat(".git/MERGE_HEAD"); statErr == nil {
-			// MERGE_HEAD exists — this is a real merge conflict
-			conflictingFiles := []string{}
-			filesOutput, filesErr := r.ExecuteGitCommandQuiet("diff", "--name-only", "--diff-filter=U")
-			if filesErr == nil && filesOutput != "" {
-				conflictingFiles = strings.Split(filesOutput, "\n")
-			}
-
-			r.ExecuteGitCommandQuiet("merge", "--abort")
+		} else if len(conflictingFiles) > 0 {
 			sequential = false
 			result.OK = false
 			result.BranchResults = append(result.BranchResults, MultiMergeTestBranchResult{
@@ -428,31 +865,103 @@ func (r *LocalRepository) MultiMergeTest() (*MultiMergeTestResult, error) {
 				ConflictingFiles: conflictingFiles,
 			})
 		} else {
-			// No MERGE_HEAD — git merge failed for a non-conflict reason
-			errMsg := strings.TrimSpace(mergeOutput)
-			if errMsg == "" {
-				errMsg = mergeErr.Error()
+			// Commit the merged tree to advance the base for subsequent branches
+			if sequential {
+				mergedSha, err = r.commitMergeTree(tree, mergedSha, branchNameToMerge, reference)
+				if err != nil {
+					return nil, err
+				}
 			}
-			r.Err("merge error for %s: %s", reference.Name, errMsg)
-
-			sequential = false
-			result.OK = false
 			result.BranchResults = append(result.BranchResults, MultiMergeTestBranchResult{
 				Name:      reference.Name,
-				Status:    "error",
+				Status:    "clean",
 				MergeType: mergeType,
-				Error:     errMsg,
 			})
 		}
 	}
 
-	// Restore original state
-	r.Note("Restore original branch")
-	r.ExecuteGitCommand("checkout", originalBranch)
-
 	return result, nil
 }
 
+// fetchMultiMergeBase fetches the remotes and reference refs of a manifest and returns the commit its base branch is at
+func (r *LocalRepository) fetchMultiMergeBase(manifest *MultiMergeManifest) (string, error) {
+	if err := r.fetchMultiMergeRemotes(manifest); err != nil {
+		return "", err
+	}
+	if err := r.fetchReferenceRefs(manifest); err != nil {
+		return "", err
+	}
+
+	baseBranchName, err := r.multiMergeBaseBranch(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	return r.GetSha(baseBranchName)
+}
+
+// commitMergeTree commits a tree made by mergeTree as a dangling merge commit, or as a single parent commit
+// for squashed references
+func (r *LocalRepository) commitMergeTree(tree, ours, theirs string, reference MultiMergeReference) (string, error) {
+	parents := []string{"-p", ours, "-p", theirs}
+	if reference.Options.Squash {
+		parents = parents[:2]
+	}
+
+	commitArgs := append([]string{"commit-tree", tree}, parents...)
+	output, err := r.ExecuteGitCommandQuiet(append(commitArgs, "-m", fmt.Sprintf("test merge %s", reference.Name))...)
+	if err != nil {
+		return "", fmt.Errorf("committing test merge of %s: %s", reference.Name, strings.TrimSpace(output))
+	}
+
+	return output, nil
+}
+
+// mergeTree merges two commits in memory, returning the merged tree, or the conflicting files when
+// the merge has conflicts. Nothing but objects in the object database is written.
+//
+// git merge-tree always uses the ort strategy, so of the reference options only the strategy options and
+// allowing unrelated histories apply.
+func (r *LocalRepository) mergeTree(ours, theirs string, options MultiMergeReferenceOptions) (string, []string, error) {
+	mergeTreeArgs := []string{"merge-tree", "--write-tree", "--name-only"}
+	for _, strategyOption := range options.StrategyOptions {
+		mergeTreeArgs = append(mergeTreeArgs, "-X", strategyOption)
+	}
+	if options.AllowUnrelatedHistories {
+		mergeTreeArgs = append(mergeTreeArgs, "--allow-unrelated-histories")
+	}
+	stdout, stderr, err := r.executeGitCommandCapture("", append(mergeTreeArgs, ours, theirs)...)
+
+	// Exit code 1 with a tree means conflicts, the tree is followed by the conflicting files and a blank line
+	lines := strings.Split(stdout, "\n")
+	tree := strings.TrimSpace(lines[0])
+	if err == nil {
+		return tree, nil, nil
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 || tree == "" {
+		// Only the first line, unsupported options make git print its usage
+		message, _, _ := strings.Cut(strings.TrimSpace(stderr), "\n")
+		if message == "" {
+			message = err.Error()
+		}
+		return "", nil, errors.New(message)
+	}
+
+	conflictingFiles := []string{}
+	for _, line := range lines[1:] {
+		if line == "" {
+			break
+		}
+		if !slices.Contains(conflictingFiles, line) {
+			conflictingFiles = append(conflictingFiles, line)
+		}
+	}
+
+	return tree, conflictingFiles, nil
+}
+
 func (r *LocalRepository) MultiMergeCommitManifest() error {
 	r.Note("Adding manifest to git")
 	output, err := r.ExecuteGitCommand("add", ".pila_multi_merge.yaml")
@@ -0,0 +1,222 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// MultiMergeBisectResult is the outcome of bisecting the references of a manifest with a command
+type MultiMergeBisectResult struct {
+	Culprit  string   // First reference after which the command fails, empty when it never fails
+	LastGood []string // References merged in the last prefix the command succeeded with
+	Skipped  []string // References left out because they conflicted, with on_conflict set to skip
+	BaseBad  bool     // The command already fails on the base branch alone
+}
+
+// multiMergePrefix is the base with the first references of a manifest merged, as a dangling commit
+type multiMergePrefix struct {
+	Sha        string
+	References []string
+}
+
+// MultiMergeBisect finds the first reference of the manifest after which command fails. The manifest is merged
+// onto the base in memory like MultiMergeTest, one commit per reference, and the command is run on these commits
+// in a scratch worktree, binary searching for the first failing one. The checkout is left untouched, and the
+// scratch worktree is removed afterwards.
+//
+// With frozen the commits recorded in the manifest are merged, like MultiMergeUsingManifest does, to bisect the
+// target branch exactly as it was built.
+func (r *LocalRepository) MultiMergeBisect(command string, frozen bool) (*MultiMergeBisectResult, error) {
+	manifest, err := r.LoadMultiMergeManifest()
+	if err != nil {
+		return nil, err
+	}
+	if frozen {
+		if err := r.checkFrozenManifest(manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	prefixes, skipped, err := r.multiMergePrefixes(manifest, frozen)
+	if err != nil {
+		return nil, err
+	}
+	result := &MultiMergeBisectResult{LastGood: []string{}, Skipped: skipped}
+
+	worktreePath, err := r.addBisectWorktree()
+	if err != nil {
+		return nil, err
+	}
+	defer r.removeBisectWorktree(worktreePath)
+
+	run := func(index int) (bool, error) {
+		prefix := prefixes[index]
+		if len(prefix.References) == 0 {
+			r.Note("Run '%s' on the base branch", command)
+		} else {
+			r.Note("Run '%s' with %s merged", command, strings.Join(prefix.References, ", "))
+		}
+		return r.runBisectCommand(worktreePath, prefix.Sha, command)
+	}
+
+	// Git bisect style, the base has to be good and the full merge bad before there is anything to search for
+	last := len(prefixes) - 1
+	good, err := run(last)
+	if err != nil {
+		return nil, err
+	}
+	if good {
+		result.LastGood = prefixes[last].References
+		return result, nil
+	}
+	good, err = run(0)
+	if err != nil {
+		return nil, err
+	}
+	if !good {
+		result.BaseBad = true
+		return result, nil
+	}
+
+	low, high := 0, last
+	for high-low > 1 {
+		middle := (low + high) / 2
+		good, err := run(middle)
+		if err != nil {
+			return nil, err
+		}
+		if good {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+
+	result.LastGood = prefixes[low].References
+	result.Culprit = prefixes[high].References[len(prefixes[high].References)-1]
+
+	return result, nil
+}
+
+// multiMergePrefixes merges the references of the manifest onto the base one at a time, returning the base
+// followed by a commit for every reference merged. References that conflict are left out when on_conflict is
+// skip, otherwise they are an error, as nothing after them can be bisected.
+func (r *LocalRepository) multiMergePrefixes(manifest *MultiMergeManifest, frozen bool) ([]multiMergePrefix, []string, error) {
+	if err := r.fetchMultiMergeRemotes(manifest); err != nil {
+		return nil, nil, err
+	}
+	if err := r.fetchReferenceRefs(manifest); err != nil {
+		return nil, nil, err
+	}
+
+	baseSha := manifest.MainSha
+	if !frozen {
+		baseBranchName, err := r.multiMergeBaseBranch(manifest)
+		if err != nil {
+			return nil, nil, err
+		}
+		baseSha, err = r.GetSha(baseBranchName)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range manifest.References {
+			manifest.References[i].Sha = ""
+		}
+	}
+
+	prefixes := []multiMergePrefix{{Sha: baseSha, References: []string{}}}
+	skipped := []string{}
+	_, featuresRemote := r.multiMergeRemotes(manifest)
+	for i := range manifest.References {
+		reference := &manifest.References[i]
+		commitish, err := r.multiMergeCommitish(featuresRemote, reference)
+		if err != nil {
+			return nil, nil, err
+		}
+		if commitish == "" {
+			return nil, nil, fmt.Errorf("unable to find a branch named '%s'", reference.Name)
+		}
+
+		previous := prefixes[len(prefixes)-1]
+		r.Note("Test merge %s", reference.Name)
+		tree, conflictingFiles, err := r.mergeTree(previous.Sha, commitish, reference.Options)
+		if err != nil {
+			return nil, nil, fmt.Errorf("merging %s: %w", reference.Name, err)
+		}
+		if len(conflictingFiles) > 0 {
+			if manifest.Options.OnConflict != MULTI_MERGE_ON_CONFLICT_SKIP {
+				return nil, nil, fmt.Errorf("%s conflicts in %s, please resolve the conflict before bisecting", reference.Name, strings.Join(conflictingFiles, ", "))
+			}
+			r.Warn("Skip %s, conflicts in %s", reference.Name, strings.Join(conflictingFiles, ", "))
+			skipped = append(skipped, reference.Name)
+			continue
+		}
+
+		sha, err := r.commitMergeTree(tree, previous.Sha, commitish, *reference)
+		if err != nil {
+			return nil, nil, err
+		}
+		prefixes = append(prefixes, multiMergePrefix{
+			Sha:        sha,
+			References: append(append([]string{}, previous.References...), reference.Name),
+		})
+	}
+
+	return prefixes, skipped, nil
+}
+
+// addBisectWorktree creates the scratch worktree commands are run in while bisecting, .git/pila/bisect
+func (r *LocalRepository) addBisectWorktree() (string, error) {
+	commonDir, err := r.GitCommonDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(commonDir, "pila", "bisect")
+
+	// Left behind by a bisect that was killed
+	r.removeBisectWorktree(path)
+
+	r.Note("Create scratch worktree in %s", path)
+	output, err := r.ExecuteGitCommand("worktree", "add", "--detach", path)
+	if err != nil {
+		return "", fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	return path, nil
+}
+
+func (r *LocalRepository) removeBisectWorktree(path string) {
+	r.ExecuteGitCommandQuiet("worktree", "remove", "--force", path)
+	os.RemoveAll(path)
+	r.ExecuteGitCommandQuiet("worktree", "prune")
+}
+
+// runBisectCommand checks out sha in the scratch worktree and runs command there through the shell, reporting
+// whether it succeeded. Untracked files left by the previous run are removed, ignored files such as build caches
+// are kept.
+func (r *LocalRepository) runBisectCommand(worktreePath, sha, command string) (bool, error) {
+	worktree := &LocalRepository{Type: r.Type, Repository: r.Repository, WorkDir: worktreePath}
+	if output, err := worktree.ExecuteGitCommandQuiet("checkout", "-q", "--force", "--detach", sha); err != nil {
+		return false, fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+	if output, err := worktree.ExecuteGitCommandQuiet("clean", "-q", "-fd"); err != nil {
+		return false, fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = worktreePath
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
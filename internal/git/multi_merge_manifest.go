@@ -1,7 +1,11 @@
 package git
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,24 +15,115 @@ const (
 
 	MULTI_MERGE_MANIFEST_TYPE_BRANCHES = "branches"
 	MULTI_MERGE_MANIFEST_TYPE_LABELS   = "labels"
+
+	MULTI_MERGE_STRATEGY_ORT       = "ort"
+	MULTI_MERGE_STRATEGY_RECURSIVE = "recursive"
+	MULTI_MERGE_STRATEGY_OCTOPUS   = "octopus"
+
+	MULTI_MERGE_ON_CONFLICT_STOP = "stop"
+	MULTI_MERGE_ON_CONFLICT_SKIP = "skip"
 )
 
 type MultiMergeManifest struct {
-	MainSha    string                `yaml:"main_sha"`
+	MainSha    string                `yaml:"main_sha"` // Commit of the base branch the target was built on
 	Target     string                `yaml:"target"`
 	Type       string                `yaml:"type"`
+	Labels     []string              `yaml:"labels,omitempty"` // Only for manifests of type labels
+	Options    MultiMergeOptions     `yaml:",inline"`
 	References []MultiMergeReference `yaml:"references"`
+
+	dir string // Directory the manifest is read from and saved to, the current directory when empty
+}
+
+// MultiMergeOptions are the settings of a multi-merge, kept in the manifest so continue and redo use them too
+type MultiMergeOptions struct {
+	Worktree bool              `yaml:"worktree,omitempty"` // Merge in a dedicated worktree instead of the current checkout
+	Base     string            `yaml:"base,omitempty"`     // Branch on the base remote to build the target on, main when empty
+	Remotes  MultiMergeRemotes `yaml:"remotes,omitempty"`
+
+	OnConflict string `yaml:"on_conflict,omitempty"` // stop to resolve conflicts by hand, or skip conflicting references
+	Verify     string `yaml:"verify,omitempty"`      // Command run after every merge, stopping the multi-merge when it fails
+}
+
+// MultiMergeRemotes override the remote.base and remote.features config settings for a single multi-merge
+type MultiMergeRemotes struct {
+	Base     string `yaml:"base,omitempty"`     // Remote the base branch is taken from
+	Features string `yaml:"features,omitempty"` // Remote the merged branches are taken from
 }
 
 type MultiMergeReference struct {
 	Name   string `yaml:"name"`
 	Merged bool   `yaml:"merged"`
-	Note   string `yaml:"note,omitempty"`
+
+	// Left out because it conflicted, with on_conflict set to skip
+	Skipped          bool     `yaml:"skipped,omitempty"`
+	ConflictingFiles []string `yaml:"conflicting_files,omitempty"`
+
+	// Merged, but the verify command failed afterwards, continue runs it again
+	VerifyFailed bool `yaml:"verify_failed,omitempty"`
+
+	Note         string `yaml:"note,omitempty"`
+	Number       int    `yaml:"number,omitempty"`        // Merge request number, only for manifests of type labels
+	URL          string `yaml:"url,omitempty"`           // Merge request URL, only for manifests of type labels
+	SourceBranch string `yaml:"source_branch,omitempty"` // Merge request branch, only for manifests of type labels
+	Ref          string `yaml:"ref,omitempty"`           // Ref to fetch from the base remote before merging, only for manifests of type labels
+	Sha          string `yaml:"sha,omitempty"`           // Commit the branch was at when it was merged
+	Pinned       bool   `yaml:"pinned,omitempty"`        // Kept at its position when the manifest is reordered
+
+	Options MultiMergeReferenceOptions `yaml:",inline"`
+}
+
+// MultiMergeReferenceOptions control how a single reference is merged. Consecutive references using the octopus
+// strategy are merged together in a single merge, with the options of the first of them.
+type MultiMergeReferenceOptions struct {
+	Strategy                string   `yaml:"strategy,omitempty"`         // ort, recursive or octopus, git decides when empty
+	StrategyOptions         []string `yaml:"strategy_options,omitempty"` // Passed on as -X, e.g. ours, theirs or patience
+	Squash                  bool     `yaml:"squash,omitempty"`           // Squash the branch into a single commit
+	NoFastForward           bool     `yaml:"no_ff,omitempty"`            // Always create a merge commit
+	AllowUnrelatedHistories bool     `yaml:"allow_unrelated_histories,omitempty"`
+}
+
+// Validate checks that the options make sense together
+func (o MultiMergeReferenceOptions) Validate() error {
+	if !slices.Contains([]string{"", MULTI_MERGE_STRATEGY_ORT, MULTI_MERGE_STRATEGY_RECURSIVE, MULTI_MERGE_STRATEGY_OCTOPUS}, o.Strategy) {
+		return fmt.Errorf("unknown merge strategy '%s', use %s, %s or %s", o.Strategy, MULTI_MERGE_STRATEGY_ORT, MULTI_MERGE_STRATEGY_RECURSIVE, MULTI_MERGE_STRATEGY_OCTOPUS)
+	}
+	if o.Squash && o.NoFastForward {
+		return errors.New("squash and no_ff cannot be combined")
+	}
+	if o.Squash && o.Strategy == MULTI_MERGE_STRATEGY_OCTOPUS {
+		return errors.New("octopus merges cannot be squashed")
+	}
+
+	return nil
+}
+
+// mergeArgs returns the git merge flags for the options
+func (o MultiMergeReferenceOptions) mergeArgs() []string {
+	args := []string{}
+	if o.Strategy != "" {
+		args = append(args, "--strategy", o.Strategy)
+	}
+	for _, strategyOption := range o.StrategyOptions {
+		args = append(args, "--strategy-option", strategyOption)
+	}
+	if o.Squash {
+		args = append(args, "--squash")
+	}
+	if o.NoFastForward {
+		args = append(args, "--no-ff")
+	}
+	if o.AllowUnrelatedHistories {
+		args = append(args, "--allow-unrelated-histories")
+	}
+
+	return args
 }
 
-func LoadMultiMergeManifest() (*MultiMergeManifest, error) {
+// LoadMultiMergeManifest loads the manifest in dir, the current directory when empty
+func LoadMultiMergeManifest(dir string) (*MultiMergeManifest, error) {
 	// Read YAML file
-	data, err := os.ReadFile(MULTI_MERGE_MANIFEST_FILENAME)
+	data, err := os.ReadFile(filepath.Join(dir, MULTI_MERGE_MANIFEST_FILENAME))
 	if err != nil {
 		return nil, err
 	}
@@ -38,14 +133,15 @@ func LoadMultiMergeManifest() (*MultiMergeManifest, error) {
 	if err != nil {
 		return nil, err
 	}
+	manifest.dir = dir
 
 	return &manifest, nil
 }
 
-// Are all branches merged
+// Are all branches merged, or skipped
 func (m *MultiMergeManifest) IsDone() bool {
 	for _, reference := range m.References {
-		if !reference.Merged {
+		if !reference.Merged && !reference.Skipped {
 			return false
 		}
 	}
@@ -53,28 +149,66 @@ func (m *MultiMergeManifest) IsDone() bool {
 	return true
 }
 
+// SkippedReferences returns the references left out because they conflicted
+func (m *MultiMergeManifest) SkippedReferences() []MultiMergeReference {
+	skipped := []MultiMergeReference{}
+	for _, reference := range m.References {
+		if reference.Skipped {
+			skipped = append(skipped, reference)
+		}
+	}
+
+	return skipped
+}
+
 // Mark all branches as un-merged
 func (m *MultiMergeManifest) Reset() bool {
 	for i := range m.References {
 		reference := &m.References[i]
 		reference.Merged = false
+		reference.Skipped = false
+		reference.ConflictingFiles = nil
+		reference.VerifyFailed = false
 	}
 
 	m.Save()
 	return true
 }
 
+// mergeGroupSize returns the number of references merged together with the reference at index, more than one
+// for a run of unmerged references using the octopus strategy
+func (m *MultiMergeManifest) mergeGroupSize(index int) int {
+	if m.References[index].Options.Strategy != MULTI_MERGE_STRATEGY_OCTOPUS {
+		return 1
+	}
+
+	size := 1
+	for _, reference := range m.References[index+1:] {
+		if reference.Merged || reference.Skipped || reference.Options.Strategy != MULTI_MERGE_STRATEGY_OCTOPUS {
+			break
+		}
+		size++
+	}
+
+	return size
+}
+
 // Save manifest to disk
 func (m *MultiMergeManifest) Save() error {
 	data, err := yaml.Marshal(m)
 	if err != nil {
 		panic(err)
 	}
-	os.WriteFile(MULTI_MERGE_MANIFEST_FILENAME, data, 0o644)
+	os.WriteFile(m.Path(), data, 0o644)
 
 	return nil
 }
 
 func (m *MultiMergeManifest) Remove() error {
-	return os.Remove(MULTI_MERGE_MANIFEST_FILENAME)
+	return os.Remove(m.Path())
+}
+
+// Path of the manifest file
+func (m *MultiMergeManifest) Path() string {
+	return filepath.Join(m.dir, MULTI_MERGE_MANIFEST_FILENAME)
 }
@@ -0,0 +1,293 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const MULTI_MERGE_PAIR_CACHE_FILENAME = "multi_merge_pairs.yaml"
+
+const (
+	MULTI_MERGE_PAIR_CLEAN    = "clean"
+	MULTI_MERGE_PAIR_CONFLICT = "conflict"
+	MULTI_MERGE_PAIR_MISSING  = "missing" // One of the branches does not exist
+	MULTI_MERGE_PAIR_SKIPPED  = "skipped" // One of the branches conflicts with the base on its own
+	MULTI_MERGE_PAIR_ERROR    = "error"
+)
+
+// MultiMergePairResult is the outcome of merging two references onto the base, one after the other.
+// A reference paired with itself is the outcome of merging it onto the base alone.
+type MultiMergePairResult struct {
+	First            string   `json:"first" yaml:"first"`
+	Second           string   `json:"second" yaml:"second"`
+	Status           string   `json:"status" yaml:"status"`                                           // "clean", "conflict", "missing", "skipped", "error"
+	ConflictingFiles []string `json:"conflicting_files,omitempty" yaml:"conflicting_files,omitempty"` // only when Status == "conflict"
+	Error            string   `json:"error,omitempty" yaml:"error,omitempty"`                         // only when Status == "error"
+}
+
+type MultiMergeMatrixResult struct {
+	OK       bool                   `json:"ok"`
+	Error    string                 `json:"error,omitempty"`
+	Branches []string               `json:"branches"`
+	Pairs    []MultiMergePairResult `json:"pairs"`
+}
+
+// Pair returns the result of merging first and then second
+func (m *MultiMergeMatrixResult) Pair(first, second string) (MultiMergePairResult, bool) {
+	for _, pair := range m.Pairs {
+		if pair.First == first && pair.Second == second {
+			return pair, true
+		}
+	}
+
+	return MultiMergePairResult{}, false
+}
+
+// MultiMergeTestMatrix merges every pair of references in the manifest onto the base in both orders, in memory
+// like MultiMergeTest, to find out which branches conflict with each other and whether that depends on the order.
+func (r *LocalRepository) MultiMergeTestMatrix() (*MultiMergeMatrixResult, error) {
+	manifest, err := r.LoadMultiMergeManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	baseSha, err := r.fetchMultiMergeBase(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.multiMergeMatrix(manifest, baseSha)
+}
+
+// multiMergeMatrix merges every pair of references onto the base in both orders
+func (r *LocalRepository) multiMergeMatrix(manifest *MultiMergeManifest, baseSha string) (*MultiMergeMatrixResult, error) {
+	tester, err := r.newPairTester(manifest, baseSha)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MultiMergeMatrixResult{
+		OK:       true,
+		Branches: []string{},
+		Pairs:    []MultiMergePairResult{},
+	}
+
+	// Every branch on its own first, so the failing branches stand out at the top of the results
+	for i, reference := range manifest.References {
+		result.Branches = append(result.Branches, reference.Name)
+
+		pair, err := tester.pair(i, i)
+		if err != nil {
+			return nil, err
+		}
+		result.Pairs = append(result.Pairs, pair)
+	}
+	for i := range manifest.References {
+		for j := range manifest.References {
+			if i == j {
+				continue
+			}
+
+			pair, err := tester.pair(i, j)
+			if err != nil {
+				return nil, err
+			}
+			result.Pairs = append(result.Pairs, pair)
+		}
+	}
+	for _, pair := range result.Pairs {
+		if pair.Status != MULTI_MERGE_PAIR_CLEAN {
+			result.OK = false
+		}
+	}
+
+	if err := tester.save(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// pairTester merges pairs of references onto the base. Results are cached by the commits involved,
+// so only pairs where the base or one of the branches moved are merged again.
+type pairTester struct {
+	r          *LocalRepository
+	baseSha    string
+	references []MultiMergeReference
+
+	branchNames []string // Branch each reference is merged from, empty when it does not exist
+	branchShas  []string
+	mergedShas  []string // Each reference merged onto the base alone, made when first needed
+
+	cache     multiMergePairCache
+	usedCache multiMergePairCache
+}
+
+func (r *LocalRepository) newPairTester(manifest *MultiMergeManifest, baseSha string) (*pairTester, error) {
+	cache, err := r.loadPairCache()
+	if err != nil {
+		return nil, err
+	}
+
+	tester := &pairTester{
+		r:           r,
+		baseSha:     baseSha,
+		references:  manifest.References,
+		branchNames: make([]string, len(manifest.References)),
+		branchShas:  make([]string, len(manifest.References)),
+		mergedShas:  make([]string, len(manifest.References)),
+		cache:       cache,
+		usedCache:   multiMergePairCache{},
+	}
+
+	_, featuresRemote := r.multiMergeRemotes(manifest)
+	for i, reference := range manifest.References {
+		branchNameToMerge, sha, err := r.resolveReferenceBranch(featuresRemote, reference.Name)
+		if err != nil {
+			continue
+		}
+		tester.branchNames[i] = branchNameToMerge
+		tester.branchShas[i] = sha
+	}
+
+	return tester, nil
+}
+
+// pair returns the result of merging reference i onto the base and then reference j on top of it,
+// or of merging reference i alone when i and j are the same
+func (p *pairTester) pair(i, j int) (MultiMergePairResult, error) {
+	first, second := p.references[i], p.references[j]
+	pair := MultiMergePairResult{First: first.Name, Second: second.Name}
+
+	if p.branchNames[i] == "" || p.branchNames[j] == "" {
+		pair.Status = MULTI_MERGE_PAIR_MISSING
+		return pair, nil
+	}
+
+	// Pairs are merged on top of the first branch merged alone, so both have to merge cleanly on their own
+	if i != j {
+		for _, k := range []int{i, j} {
+			alone, err := p.pair(k, k)
+			if err != nil {
+				return pair, err
+			}
+			if alone.Status != MULTI_MERGE_PAIR_CLEAN {
+				pair.Status = MULTI_MERGE_PAIR_SKIPPED
+				return pair, nil
+			}
+		}
+	}
+
+	key := pairCacheKey(p.baseSha, p.branchShas[i], p.branchShas[j], first, second)
+	if cachedPair, cached := p.cache[key]; cached {
+		p.usedCache[key] = cachedPair
+		return cachedPair, nil
+	}
+
+	if i == j {
+		p.r.Note("Test merge %s", first.Name)
+		pair, _ = p.r.testMergePair(p.baseSha, p.branchNames[i], first, second)
+	} else {
+		if p.mergedShas[i] == "" {
+			tree, _, err := p.r.mergeTree(p.baseSha, p.branchNames[i], first.Options)
+			if err != nil {
+				return pair, err
+			}
+			p.mergedShas[i], err = p.r.commitMergeTree(tree, p.baseSha, p.branchNames[i], first)
+			if err != nil {
+				return pair, err
+			}
+		}
+
+		p.r.Note("Test merge %s with %s", first.Name, second.Name)
+		pair, _ = p.r.testMergePair(p.mergedShas[i], p.branchNames[j], first, second)
+	}
+	p.cache[key] = pair
+	p.usedCache[key] = pair
+
+	return pair, nil
+}
+
+// save writes the results used by this tester to the cache, so the cache does not grow forever
+func (p *pairTester) save() error {
+	return p.r.savePairCache(p.usedCache)
+}
+
+// testMergePair merges the second reference onto ours, which already has the first one merged, and returns
+// the result along with the merged tree
+func (r *LocalRepository) testMergePair(ours, theirs string, first, second MultiMergeReference) (MultiMergePairResult, string) {
+	pair := MultiMergePairResult{First: first.Name, Second: second.Name, Status: MULTI_MERGE_PAIR_CLEAN}
+
+	tree, conflictingFiles, err := r.mergeTree(ours, theirs, second.Options)
+	if err != nil {
+		pair.Status = MULTI_MERGE_PAIR_ERROR
+		pair.Error = err.Error()
+	} else if len(conflictingFiles) > 0 {
+		pair.Status = MULTI_MERGE_PAIR_CONFLICT
+		pair.ConflictingFiles = conflictingFiles
+	}
+
+	return pair, tree
+}
+
+// multiMergePairCache holds pair results by the commits and options they were merged with
+type multiMergePairCache map[string]MultiMergePairResult
+
+// pairCacheKey identifies the merge of two references, at the given commits, onto the base
+func pairCacheKey(baseSha, firstSha, secondSha string, first, second MultiMergeReference) string {
+	return fmt.Sprintf("%s %s %s %s %+v %+v", baseSha, firstSha, secondSha, first.Name+":"+second.Name, first.Options, second.Options)
+}
+
+func (r *LocalRepository) pairCachePath() (string, error) {
+	commonDir, err := r.GitCommonDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(commonDir, "pila", MULTI_MERGE_PAIR_CACHE_FILENAME), nil
+}
+
+// loadPairCache loads the cached pair results, an empty cache when there are none
+func (r *LocalRepository) loadPairCache() (multiMergePairCache, error) {
+	cache := multiMergePairCache{}
+
+	path, err := r.pairCachePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cache, nil
+		}
+		return nil, err
+	}
+
+	// A broken cache is as good as no cache
+	if err := yaml.Unmarshal(data, &cache); err != nil {
+		return multiMergePairCache{}, nil
+	}
+
+	return cache, nil
+}
+
+func (r *LocalRepository) savePairCache(cache multiMergePairCache) error {
+	path, err := r.pairCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,173 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const MULTI_MERGE_PUSHED_FILENAME = "multi_merge_pushed.yaml"
+
+// ForeignCommitsError is returned when the remote target branch has commits pila did not push
+type ForeignCommitsError struct {
+	Remote     string
+	BranchName string
+	RemoteSha  string   // Commit the branch is at on the remote
+	Commits    []string // One line per commit, short sha, author and subject
+}
+
+func (e *ForeignCommitsError) Error() string {
+	return fmt.Sprintf("%s on %s has %d commits that were not pushed by pila", e.BranchName, e.Remote, len(e.Commits))
+}
+
+// MultiMergePush pushes the target branch to the features remote, with a lease on the commit pila pushed last.
+// When someone else has pushed to the branch since, their commits are listed in a ForeignCommitsError instead
+// of being overwritten. Until pila has pushed the branch once, the branch on the remote is only replaced when
+// all of its commits are part of the target branch or the base it was built on.
+func (r *LocalRepository) MultiMergePush() error {
+	manifest, err := r.LoadMultiMergeManifest()
+	if err != nil {
+		return err
+	}
+	target := manifest.Target
+	_, remote := r.multiMergeRemotes(manifest)
+
+	pushed, err := r.loadPushedShas()
+	if err != nil {
+		return err
+	}
+	pushedKey := fmt.Sprintf("%s/%s", remote, target)
+
+	sha, err := r.GetSha(fmt.Sprintf("refs/heads/%s", target))
+	if err != nil {
+		return err
+	}
+	remoteSha, err := r.remoteBranchSha(remote, target)
+	if err != nil {
+		return err
+	}
+
+	// An empty lease means the branch must not exist on the remote
+	expectedSha := remoteSha
+	lastPushedSha, found := pushed[pushedKey]
+	if remoteSha != "" && remoteSha != lastPushedSha {
+		knownShas := []string{lastPushedSha}
+		if !found {
+			knownShas = []string{sha, manifest.MainSha}
+		}
+		commits, err := r.foreignCommits(remote, target, remoteSha, knownShas)
+		if err != nil {
+			return err
+		}
+		if len(commits) > 0 {
+			return &ForeignCommitsError{Remote: remote, BranchName: target, RemoteSha: remoteSha, Commits: commits}
+		}
+	}
+
+	r.Note("Push %s to %s", target, remote)
+	output, err := r.ExecuteGitCommand(
+		"push",
+		fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", target, expectedSha),
+		remote,
+		fmt.Sprintf("refs/heads/%s:refs/heads/%s", target, target),
+	)
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	pushed[pushedKey] = sha
+	return r.savePushedShas(pushed)
+}
+
+// remoteBranchSha asks the remote which commit a branch is at, empty when it does not exist
+func (r *LocalRepository) remoteBranchSha(remote, branchName string) (string, error) {
+	output, err := r.ExecuteGitCommandQuiet("ls-remote", "--heads", remote, fmt.Sprintf("refs/heads/%s", branchName))
+	if err != nil {
+		return "", fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	sha, _, _ := strings.Cut(output, "\t")
+	return sha, nil
+}
+
+// foreignCommits fetches the remote branch and lists the commits on it that are not reachable from any of the
+// known commits
+func (r *LocalRepository) foreignCommits(remote, branchName, remoteSha string, knownShas []string) ([]string, error) {
+	if output, err := r.ExecuteGitCommand("fetch", remote, fmt.Sprintf("refs/heads/%s", branchName)); err != nil {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	// The commit pila pushed may be gone, e.g. after a gc, then every commit on the remote branch is foreign
+	logArgs := []string{"log", "--format=%h %an: %s", remoteSha, "--not"}
+	for _, knownSha := range knownShas {
+		if knownSha == "" {
+			continue
+		}
+		if _, err := r.GetSha(knownSha + "^{commit}"); err == nil {
+			logArgs = append(logArgs, knownSha)
+		}
+	}
+
+	output, err := r.ExecuteGitCommandQuiet(logArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+	if output == "" {
+		// Commits were only removed from the remote branch, nothing is lost by pushing
+		return []string{}, nil
+	}
+
+	return strings.Split(output, "\n"), nil
+}
+
+func (r *LocalRepository) pushedShasPath() (string, error) {
+	commonDir, err := r.GitCommonDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(commonDir, "pila", MULTI_MERGE_PUSHED_FILENAME), nil
+}
+
+// loadPushedShas loads the commit pila last pushed of every target, by <remote>/<target>
+func (r *LocalRepository) loadPushedShas() (map[string]string, error) {
+	pushed := map[string]string{}
+
+	path, err := r.pushedShasPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return pushed, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &pushed); err != nil {
+		return nil, err
+	}
+
+	return pushed, nil
+}
+
+func (r *LocalRepository) savePushedShas(pushed map[string]string) error {
+	path, err := r.pushedShasPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(pushed)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
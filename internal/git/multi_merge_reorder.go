@@ -0,0 +1,253 @@
+package git
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MultiMergeReorderResult is the outcome of searching for an order of the references that merges cleanly
+type MultiMergeReorderResult struct {
+	Found            bool                   // An order merging cleanly was found
+	Changed          bool                   // The order found differs from the order in the manifest
+	Order            []string               // The order found, only when Found is true
+	ConflictingPairs []MultiMergePairResult // Why no order was found, pairs conflicting in both orders or branches conflicting with the base
+}
+
+// MultiMergeReorder searches for an order of the references in the manifest that merges cleanly, and saves the
+// manifest in that order when rewrite is set. Pinned references, and the references named in pins, are kept at
+// their position.
+//
+// Every pair of references is merged onto the base in both orders first, using the cached results of earlier
+// runs. A branch conflicting with the base, or a pair conflicting in both orders, means no order merges cleanly.
+// The orders are then tried depth first, in memory like MultiMergeTest, starting with the current order. No
+// reference is tried after a reference it conflicts with as a pair, and every set of references that failed
+// to merge is remembered with the tree it merged to, so no order starting with the same set and tree is tried
+// again.
+func (r *LocalRepository) MultiMergeReorder(pins []string, rewrite bool) (*MultiMergeReorderResult, error) {
+	manifest, err := r.LoadMultiMergeManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pin := range pins {
+		index := slices.IndexFunc(manifest.References, func(reference MultiMergeReference) bool { return reference.Name == pin })
+		if index == -1 {
+			return nil, fmt.Errorf("branch '%s' is not in the manifest", pin)
+		}
+		manifest.References[index].Pinned = true
+	}
+
+	baseSha, err := r.fetchMultiMergeBase(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	tester, err := r.newPairTester(manifest, baseSha)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MultiMergeReorderResult{ConflictingPairs: []MultiMergePairResult{}}
+	missing := []string{}
+	for i, reference := range manifest.References {
+		alone, err := tester.pair(i, i)
+		if err != nil {
+			return nil, err
+		}
+		switch alone.Status {
+		case MULTI_MERGE_PAIR_MISSING:
+			missing = append(missing, reference.Name)
+		case MULTI_MERGE_PAIR_CONFLICT, MULTI_MERGE_PAIR_ERROR:
+			result.ConflictingPairs = append(result.ConflictingPairs, alone)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("branches not found: %s", strings.Join(missing, ", "))
+	}
+
+	// conflictsAfter[i][j] is set when reference j conflicts when merged after reference i
+	conflictsAfter := make([][]bool, len(manifest.References))
+	for i := range conflictsAfter {
+		conflictsAfter[i] = make([]bool, len(manifest.References))
+	}
+	if len(result.ConflictingPairs) == 0 {
+		for i := range manifest.References {
+			for j := i + 1; j < len(manifest.References); j++ {
+				forward, err := tester.pair(i, j)
+				if err != nil {
+					return nil, err
+				}
+				backward, err := tester.pair(j, i)
+				if err != nil {
+					return nil, err
+				}
+
+				conflictsAfter[i][j] = forward.Status != MULTI_MERGE_PAIR_CLEAN
+				conflictsAfter[j][i] = backward.Status != MULTI_MERGE_PAIR_CLEAN
+				if conflictsAfter[i][j] && conflictsAfter[j][i] {
+					result.ConflictingPairs = append(result.ConflictingPairs, forward)
+				}
+			}
+		}
+	}
+	if err := tester.save(); err != nil {
+		return nil, err
+	}
+	if len(result.ConflictingPairs) > 0 {
+		return result, nil
+	}
+
+	order, err := r.searchMergeOrder(manifest, baseSha, tester.branchNames, conflictsAfter)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return result, nil
+	}
+
+	result.Found = true
+	references := []MultiMergeReference{}
+	for position, index := range order {
+		references = append(references, manifest.References[index])
+		result.Order = append(result.Order, manifest.References[index].Name)
+		if index != position {
+			result.Changed = true
+		}
+	}
+
+	if !rewrite {
+		return result, nil
+	}
+
+	// The target has to be rebuilt in the new order, so no reference counts as merged
+	if result.Changed {
+		manifest.References = references
+		manifest.Reset()
+	}
+	if result.Changed || len(pins) > 0 {
+		if err := manifest.Save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// searchMergeOrder returns the indexes of the references in an order that merges cleanly onto the base,
+// or nil when there is no such order. Octopus groups are merged together, so they are placed as a whole.
+func (r *LocalRepository) searchMergeOrder(manifest *MultiMergeManifest, baseSha string, branchNames []string, conflictsAfter [][]bool) ([]int, error) {
+	// Consecutive references using the octopus strategy form a group, the others are groups of their own,
+	// and a group containing a pinned reference keeps its position as a whole
+	groups := [][]int{}
+	pinnedAt := make([]bool, len(manifest.References))
+	for i, reference := range manifest.References {
+		octopus := reference.Options.Strategy == MULTI_MERGE_STRATEGY_OCTOPUS
+		if i > 0 && octopus && manifest.References[i-1].Options.Strategy == MULTI_MERGE_STRATEGY_OCTOPUS {
+			groups[len(groups)-1] = append(groups[len(groups)-1], i)
+		} else {
+			groups = append(groups, []int{i})
+		}
+	}
+	for _, group := range groups {
+		if slices.ContainsFunc(group, func(i int) bool { return manifest.References[i].Pinned }) {
+			for _, i := range group {
+				pinnedAt[i] = true
+			}
+		}
+	}
+
+	// Strategy options such as theirs make the tree depend on the order the references were merged in, so
+	// a set of references that failed is only skipped when another order of it gives the same tree
+	failedSets := map[string]bool{}
+	placed := make([]bool, len(manifest.References))
+	order := []int{}
+
+	var search func(mergedSha, mergedTree string) (bool, error)
+	search = func(mergedSha, mergedTree string) (bool, error) {
+		position := len(order)
+		if position == len(manifest.References) {
+			return true, nil
+		}
+
+		setKey := fmt.Sprintf("%v %s", placed, mergedTree)
+		if failedSets[setKey] {
+			return false, nil
+		}
+
+		// Pinned groups can only go at their own position, others are tried in manifest order where they fit
+		// without covering a pinned position
+		candidates := [][]int{}
+		for _, group := range groups {
+			if placed[group[0]] {
+				continue
+			}
+
+			end := position + len(group)
+			switch {
+			case pinnedAt[position]:
+				if group[0] == position {
+					candidates = append(candidates, group)
+				}
+			case !pinnedAt[group[0]] && end <= len(manifest.References) && !slices.Contains(pinnedAt[position:end], true):
+				candidates = append(candidates, group)
+			}
+		}
+
+		for _, group := range candidates {
+			if slices.ContainsFunc(order, func(j int) bool {
+				return slices.ContainsFunc(group, func(i int) bool { return conflictsAfter[j][i] })
+			}) {
+				continue
+			}
+
+			// The members of a group are merged one at a time, like MultiMergeTest does
+			nextSha, nextTree, clean := mergedSha, mergedTree, true
+			for _, i := range group {
+				reference := manifest.References[i]
+				tree, conflictingFiles, err := r.mergeTree(nextSha, branchNames[i], reference.Options)
+				if err != nil || len(conflictingFiles) > 0 {
+					clean = false
+					break
+				}
+				nextSha, err = r.commitMergeTree(tree, nextSha, branchNames[i], reference)
+				if err != nil {
+					return false, err
+				}
+				nextTree = tree
+			}
+			if !clean {
+				continue
+			}
+
+			for _, i := range group {
+				placed[i] = true
+			}
+			order = append(order, group...)
+			found, err := search(nextSha, nextTree)
+			if err != nil || found {
+				return found, err
+			}
+			for _, i := range group {
+				placed[i] = false
+			}
+			order = order[:position]
+		}
+
+		failedSets[setKey] = true
+		return false, nil
+	}
+
+	baseTree, err := r.GetSha(baseSha + "^{tree}")
+	if err != nil {
+		return nil, err
+	}
+
+	r.Note("Search for an order merging cleanly")
+	found, err := search(baseSha, baseTree)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return order, nil
+}
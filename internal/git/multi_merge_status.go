@@ -0,0 +1,135 @@
+package git
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MultiMergeReferenceStatus compares a reference of the manifest with its branch on the features remote
+type MultiMergeReferenceStatus struct {
+	Name    string
+	Merged  bool
+	Skipped bool
+
+	MergedSha    string // Commit merged into the target, empty when not merged yet
+	RemoteSha    string // Commit the branch is at on the features remote now, empty when deleted
+	Ahead        int    // Commits on the remote branch that were not merged into the target
+	Behind       int    // Commits merged into the target that are gone from the remote branch, e.g. after a force push
+	Deleted      bool   // The branch no longer exists on the features remote
+	MergedToBase bool   // The branch has been merged into the base branch, and is not needed anymore
+}
+
+// MultiMergeStatusResult tells whether the target is still up to date with its base and branches
+type MultiMergeStatusResult struct {
+	BaseBranch string // Base branch on the base remote, e.g. origin/main
+	MainSha    string // Commit of the base branch the target was built on
+	BaseSha    string // Commit the base branch is at now
+	BaseAhead  int    // Commits on the base branch since the target was built
+
+	References []MultiMergeReferenceStatus
+}
+
+// Stale reports whether the base branch or any of the branches moved since the target was built, so a redo
+// would give another result. Branches that were reset or rewritten count as moved, even without new commits.
+func (s *MultiMergeStatusResult) Stale() bool {
+	if s.BaseAhead > 0 {
+		return true
+	}
+	for _, reference := range s.References {
+		if reference.Deleted || (reference.MergedSha != "" && reference.RemoteSha != reference.MergedSha) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MultiMergeStatus compares the manifest with the remotes, fetching them first unless fetch is false
+func (r *LocalRepository) MultiMergeStatus(fetch bool) (*MultiMergeStatusResult, error) {
+	manifest, err := r.LoadMultiMergeManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	// Pruned, so deleted branches show up as deleted
+	if fetch {
+		if err := r.fetchMultiMergeRemotes(manifest, "--prune"); err != nil {
+			return nil, err
+		}
+		if err := r.fetchReferenceRefs(manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	baseBranchName, err := r.multiMergeBaseBranch(manifest)
+	if err != nil {
+		return nil, err
+	}
+	baseSha, err := r.GetSha(baseBranchName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MultiMergeStatusResult{
+		BaseBranch: baseBranchName,
+		MainSha:    manifest.MainSha,
+		BaseSha:    baseSha,
+		References: []MultiMergeReferenceStatus{},
+	}
+	if manifest.MainSha != "" {
+		result.BaseAhead, err = r.countCommits(manifest.MainSha, baseSha)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, featuresRemote := r.multiMergeRemotes(manifest)
+	for _, reference := range manifest.References {
+		status := MultiMergeReferenceStatus{
+			Name:    reference.Name,
+			Merged:  reference.Merged,
+			Skipped: reference.Skipped,
+		}
+		if reference.Merged {
+			status.MergedSha = reference.Sha
+		}
+		status.RemoteSha, _ = r.GetSha(fmt.Sprintf("refs/remotes/%s/%s", featuresRemote, reference.Name))
+		status.Deleted = status.RemoteSha == ""
+
+		if status.MergedSha != "" && !status.Deleted {
+			status.Ahead, err = r.countCommits(status.MergedSha, status.RemoteSha)
+			if err != nil {
+				return nil, err
+			}
+			status.Behind, err = r.countCommits(status.RemoteSha, status.MergedSha)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Deleted branches are usually deleted because they were merged, check the last commit known of them
+		sha := status.RemoteSha
+		if sha == "" {
+			sha = reference.Sha
+		}
+		if sha != "" {
+			_, err := r.ExecuteGitCommandQuiet("merge-base", "--is-ancestor", sha, baseSha)
+			status.MergedToBase = err == nil
+		}
+
+		result.References = append(result.References, status)
+	}
+
+	return result, nil
+}
+
+// countCommits returns the number of commits reachable from to, but not from from
+func (r *LocalRepository) countCommits(from, to string) (int, error) {
+	output, err := r.ExecuteGitCommandQuiet("rev-list", "--count", fmt.Sprintf("%s..%s", from, to))
+	if err != nil {
+		return 0, fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	return strconv.Atoi(output)
+}
@@ -2,7 +2,17 @@ package git
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+
+	"go.olrik.dev/pila/internal/core"
 )
 
 func TestMultiMergeTestResult_JSONSerialization_ErrorOmittedWhenEmpty(t *testing.T) {
@@ -108,3 +118,958 @@ func TestMultiMergeTestBranchResult_JSONSerialization_ErrorPresentWhenSet(t *tes
 		t.Errorf("error = %q, want %q", errorValue, "git merge failed: uncommitted changes")
 	}
 }
+
+func TestMultiMergeTest_LeavesCheckoutUntouched(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "checkout", "-q", "-b", "feature-b", "main")
+	commitFile(t, "shared.txt", "b")
+	gitCommand(t, "checkout", "-q", "-b", "feature-c", "main")
+	commitFile(t, "shared.txt", "c")
+	gitCommand(t, "checkout", "-q", "-b", "feature-d", "main")
+	commitFile(t, "d.txt", "d")
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b", "feature-c", "feature-d")
+
+	manifest := &MultiMergeManifest{Target: "staging", Type: MULTI_MERGE_MANIFEST_TYPE_BRANCHES}
+	for _, name := range []string{"feature-a", "feature-b", "feature-c", "feature-d", "feature-gone"} {
+		manifest.References = append(manifest.References, MultiMergeReference{Name: name})
+	}
+	if err := manifest.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("README.md", []byte("work in progress\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	head := gitCommand(t, "rev-parse", "HEAD")
+	status := gitCommand(t, "status", "--porcelain")
+
+	result, err := repo.MultiMergeTest()
+	if err != nil {
+		t.Fatalf("MultiMergeTest() error = %v", err)
+	}
+
+	want := []MultiMergeTestBranchResult{
+		{Name: "feature-a", Status: "clean", MergeType: "sequential"},
+		{Name: "feature-b", Status: "clean", MergeType: "sequential"},
+		{Name: "feature-c", Status: "conflict", MergeType: "sequential", ConflictingFiles: []string{"shared.txt"}},
+		{Name: "feature-d", Status: "clean", MergeType: "main-only"},
+		{Name: "feature-gone", Status: "missing"},
+	}
+	if result.OK {
+		t.Error("result OK = true, want false")
+	}
+	if len(result.BranchResults) != len(want) {
+		t.Fatalf("got %d branch results, want %d: %+v", len(result.BranchResults), len(want), result.BranchResults)
+	}
+	for i, got := range result.BranchResults {
+		if got.Name != want[i].Name || got.Status != want[i].Status || got.MergeType != want[i].MergeType ||
+			!slices.Equal(got.ConflictingFiles, want[i].ConflictingFiles) {
+			t.Errorf("branch result %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	if got := gitCommand(t, "rev-parse", "HEAD"); got != head {
+		t.Errorf("HEAD = %s, want %s", got, head)
+	}
+	if got := gitCommand(t, "status", "--porcelain"); got != status {
+		t.Errorf("status = %q, want %q", got, status)
+	}
+}
+
+func TestMultiMergeUsingManifest_Frozen(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "checkout", "-q", "-b", "feature-b", "main")
+	commitFile(t, "b.txt", "b")
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b")
+
+	manifest, err := repo.MultiMergeNamedBranches("staging", []string{"feature-a", "feature-b"}, MultiMergeOptions{})
+	if err != nil {
+		t.Fatalf("MultiMergeNamedBranches() error = %v", err)
+	}
+	recordedSha := gitCommand(t, "rev-parse", "feature-a")
+	if manifest.References[0].Sha != recordedSha {
+		t.Fatalf("recorded sha = %q, want %s", manifest.References[0].Sha, recordedSha)
+	}
+	if manifest.MainSha != gitCommand(t, "rev-parse", "origin/main") {
+		t.Errorf("main sha = %q, want origin/main", manifest.MainSha)
+	}
+
+	// Move feature-a on, the frozen redo must ignore it
+	gitCommand(t, "checkout", "-q", "feature-a")
+	commitFile(t, "a.txt", "a moved")
+	gitCommand(t, "push", "-q", "origin", "feature-a")
+	movedSha := gitCommand(t, "rev-parse", "feature-a")
+	gitCommand(t, "checkout", "-q", "staging")
+
+	if got := repo.ReferenceSha(manifest, "feature-a"); got != movedSha {
+		t.Errorf("ReferenceSha() = %s, want %s", got, movedSha)
+	}
+
+	if err := repo.MultiMergeUsingManifest(true); err != nil {
+		t.Fatalf("MultiMergeUsingManifest(true) error = %v", err)
+	}
+	if _, err := repo.ExecuteGitCommandQuiet("merge-base", "--is-ancestor", recordedSha, "staging"); err != nil {
+		t.Errorf("recorded sha %s is not merged into staging", recordedSha)
+	}
+	if _, err := repo.ExecuteGitCommandQuiet("merge-base", "--is-ancestor", movedSha, "staging"); err == nil {
+		t.Errorf("moved sha %s was merged into a frozen redo", movedSha)
+	}
+
+	// A regular redo picks up the moved branch and records its new sha
+	if err := repo.MultiMergeUsingManifest(false); err != nil {
+		t.Fatalf("MultiMergeUsingManifest(false) error = %v", err)
+	}
+	loaded, err := LoadMultiMergeManifest("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.References[0].Sha != movedSha {
+		t.Errorf("recorded sha after redo = %q, want %s", loaded.References[0].Sha, movedSha)
+	}
+}
+
+func TestMultiMergeReferenceOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options MultiMergeReferenceOptions
+		wantErr bool
+	}{
+		{"defaults", MultiMergeReferenceOptions{}, false},
+		{"ort with options", MultiMergeReferenceOptions{Strategy: "ort", StrategyOptions: []string{"theirs", "patience"}}, false},
+		{"octopus no-ff", MultiMergeReferenceOptions{Strategy: "octopus", NoFastForward: true}, false},
+		{"unknown strategy", MultiMergeReferenceOptions{Strategy: "resolve-everything"}, true},
+		{"squash and no-ff", MultiMergeReferenceOptions{Squash: true, NoFastForward: true}, true},
+		{"squashed octopus", MultiMergeReferenceOptions{Strategy: "octopus", Squash: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMultiMergeReferences_Options(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "checkout", "-q", "-b", "feature-b", "main")
+	commitFile(t, "b.txt", "b")
+	gitCommand(t, "checkout", "-q", "-b", "feature-c", "main")
+	commitFile(t, "c.txt", "c")
+	commitFile(t, "c.txt", "c again")
+	gitCommand(t, "checkout", "-q", "-b", "feature-d", "main")
+	commitFile(t, "c.txt", "d")
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b", "feature-c", "feature-d")
+
+	octopus := MultiMergeReferenceOptions{Strategy: MULTI_MERGE_STRATEGY_OCTOPUS, NoFastForward: true}
+	references := []MultiMergeReference{
+		{Name: "feature-a", Options: octopus},
+		{Name: "feature-b", Options: octopus},
+		{Name: "feature-c", Options: MultiMergeReferenceOptions{Squash: true}},
+		{Name: "feature-d", Options: MultiMergeReferenceOptions{StrategyOptions: []string{"theirs"}, NoFastForward: true}},
+	}
+
+	manifest, err := repo.MultiMergeReferences("staging", references, MultiMergeOptions{})
+	if err != nil {
+		t.Fatalf("MultiMergeReferences() error = %v", err)
+	}
+	if !manifest.IsDone() {
+		t.Fatal("multi-merge is not done")
+	}
+
+	// Skip the manifest commit, then expect: -X theirs merge of d, squash of c, octopus of a and b
+	log := gitCommand(t, "log", "--first-parent", "--format=%p|%s", "staging~1", "-3")
+	lines := strings.Split(log, "\n")
+	if len(lines) != 3 {
+		t.Fatalf("log = %q, want 3 commits", log)
+	}
+	if parents, _, _ := strings.Cut(lines[0], "|"); len(strings.Fields(parents)) != 2 {
+		t.Errorf("merge of feature-d = %q, want a merge commit", lines[0])
+	}
+	if parents, subject, _ := strings.Cut(lines[1], "|"); len(strings.Fields(parents)) != 1 || !strings.HasPrefix(subject, "Squash branch 'feature-c'") {
+		t.Errorf("squash of feature-c = %q, want a single parent squash commit", lines[1])
+	}
+	if parents, _, _ := strings.Cut(lines[2], "|"); len(strings.Fields(parents)) != 3 {
+		t.Errorf("octopus merge = %q, want three parents", lines[2])
+	}
+	if data, _ := os.ReadFile("c.txt"); string(data) != "d\n" {
+		t.Errorf("c.txt = %q, want the -X theirs side", data)
+	}
+}
+
+func TestMultiMergeNamedBranches_Base(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "release/2.x")
+	commitFile(t, "release.txt", "2.x")
+	gitCommand(t, "checkout", "-q", "-b", "feature-a", "main")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "checkout", "-q", "main")
+	commitFile(t, "README.md", "main moved on")
+	newTestRemote(t, "release/2.x", "feature-a")
+
+	manifest, err := repo.MultiMergeNamedBranches("release-staging", []string{"feature-a"}, MultiMergeOptions{Base: "release/2.x"})
+	if err != nil {
+		t.Fatalf("MultiMergeNamedBranches() error = %v", err)
+	}
+	releaseSha := gitCommand(t, "rev-parse", "origin/release/2.x")
+	if manifest.MainSha != releaseSha {
+		t.Errorf("main sha = %s, want origin/release/2.x at %s", manifest.MainSha, releaseSha)
+	}
+	if _, err := repo.ExecuteGitCommandQuiet("merge-base", "--is-ancestor", "origin/main", "release-staging"); err == nil {
+		t.Error("origin/main was merged into a target based on release/2.x")
+	}
+
+	result, err := repo.MultiMergeTest()
+	if err != nil || !result.OK {
+		t.Fatalf("MultiMergeTest() = %+v, %v", result, err)
+	}
+
+	if err := repo.MultiMergeAbort(); err != nil {
+		t.Fatalf("MultiMergeAbort() error = %v", err)
+	}
+	if got := gitCommand(t, "rev-parse", "release-staging"); got != releaseSha {
+		t.Errorf("target after abort = %s, want origin/release/2.x at %s", got, releaseSha)
+	}
+}
+
+func TestMultiMergeNamedBranches_SkipConflicts(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "shared.txt", "a")
+	gitCommand(t, "checkout", "-q", "-b", "feature-b", "main")
+	commitFile(t, "shared.txt", "b")
+	gitCommand(t, "checkout", "-q", "-b", "feature-c", "main")
+	commitFile(t, "c.txt", "c")
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b", "feature-c")
+
+	// The completion hook gets the target and the skipped branches
+	hookDir := PILA_HOOK_DIRECTORY
+	if err := os.MkdirAll(hookDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	hookScript := "#!/bin/sh\necho \"$@\" > hook-args.txt\n"
+	if err := os.WriteFile(filepath.Join(hookDir, PILA_HOOK_MULTI_MERGE_COMPLETE), []byte(hookScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	options := MultiMergeOptions{OnConflict: MULTI_MERGE_ON_CONFLICT_SKIP}
+	manifest, err := repo.MultiMergeNamedBranches("nightly", []string{"feature-a", "feature-b", "feature-c"}, options)
+	if err != nil {
+		t.Fatalf("MultiMergeNamedBranches() error = %v", err)
+	}
+	if !manifest.IsDone() {
+		t.Fatal("multi-merge is not done")
+	}
+
+	skipped := manifest.SkippedReferences()
+	if len(skipped) != 1 || skipped[0].Name != "feature-b" || !slices.Equal(skipped[0].ConflictingFiles, []string{"shared.txt"}) {
+		t.Errorf("skipped references = %+v, want feature-b conflicting in shared.txt", skipped)
+	}
+	if !manifest.References[2].Merged {
+		t.Error("feature-c was not merged after skipping feature-b")
+	}
+	if repo.MergeInProgress() {
+		t.Error("the conflicting merge was left in progress")
+	}
+	if data, _ := os.ReadFile("shared.txt"); string(data) != "a\n" {
+		t.Errorf("shared.txt = %q, want the feature-a version", data)
+	}
+	if data, _ := os.ReadFile("hook-args.txt"); string(data) != "nightly feature-b\n" {
+		t.Errorf("hook arguments = %q, want the target and feature-b", data)
+	}
+}
+
+func TestMultiMergeTestMatrix(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "shared.txt", "a")
+	gitCommand(t, "checkout", "-q", "-b", "feature-b", "main")
+	commitFile(t, "b.txt", "b")
+	gitCommand(t, "checkout", "-q", "-b", "feature-e")
+	commitFile(t, "b.txt", "e")
+	gitCommand(t, "checkout", "-q", "-b", "feature-c", "main")
+	commitFile(t, "shared.txt", "c")
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b", "feature-c", "feature-e")
+
+	// feature-e is built on feature-b, so it only conflicts when merged after feature-b is squashed
+	manifest := &MultiMergeManifest{Target: "staging", Type: MULTI_MERGE_MANIFEST_TYPE_BRANCHES}
+	for _, name := range []string{"feature-a", "feature-e", "feature-b", "feature-c", "feature-gone"} {
+		manifest.References = append(manifest.References, MultiMergeReference{Name: name})
+	}
+	manifest.References[2].Options.Squash = true
+	if err := manifest.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := repo.MultiMergeTestMatrix()
+	if err != nil {
+		t.Fatalf("MultiMergeTestMatrix() error = %v", err)
+	}
+	if result.OK {
+		t.Error("result OK = true, want false")
+	}
+
+	// Every pair in both orders, plus every branch on its own
+	if len(result.Pairs) != 5+20 {
+		t.Errorf("got %d pairs, want 25", len(result.Pairs))
+	}
+
+	tests := []struct {
+		first, second string
+		status        string
+		files         []string
+	}{
+		{"feature-a", "feature-a", MULTI_MERGE_PAIR_CLEAN, nil},
+		{"feature-a", "feature-b", MULTI_MERGE_PAIR_CLEAN, nil},
+		{"feature-a", "feature-c", MULTI_MERGE_PAIR_CONFLICT, []string{"shared.txt"}},
+		{"feature-c", "feature-a", MULTI_MERGE_PAIR_CONFLICT, []string{"shared.txt"}},
+		{"feature-e", "feature-b", MULTI_MERGE_PAIR_CLEAN, nil},
+		{"feature-b", "feature-e", MULTI_MERGE_PAIR_CONFLICT, []string{"b.txt"}},
+		{"feature-b", "feature-c", MULTI_MERGE_PAIR_CLEAN, nil},
+		{"feature-gone", "feature-gone", MULTI_MERGE_PAIR_MISSING, nil},
+		{"feature-b", "feature-gone", MULTI_MERGE_PAIR_MISSING, nil},
+	}
+	for _, tt := range tests {
+		pair, ok := result.Pair(tt.first, tt.second)
+		if !ok {
+			t.Errorf("no result for %s and %s", tt.first, tt.second)
+			continue
+		}
+		if pair.Status != tt.status || !slices.Equal(pair.ConflictingFiles, tt.files) {
+			t.Errorf("pair %s and %s = %+v, want %s %v", tt.first, tt.second, pair, tt.status, tt.files)
+		}
+	}
+}
+
+func TestMultiMergeReorder(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// feature-b is built on feature-a, so squashing feature-a first makes feature-b conflict with the squash
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "shared.txt", "a")
+	gitCommand(t, "checkout", "-q", "-b", "feature-b")
+	commitFile(t, "shared.txt", "b")
+	gitCommand(t, "checkout", "-q", "-b", "feature-c", "main")
+	commitFile(t, "c.txt", "c")
+	gitCommand(t, "checkout", "-q", "-b", "feature-d", "main")
+	commitFile(t, "shared.txt", "d")
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b", "feature-c", "feature-d")
+
+	saveManifest := func(references ...MultiMergeReference) {
+		t.Helper()
+		manifest := &MultiMergeManifest{Target: "staging", Type: MULTI_MERGE_MANIFEST_TYPE_BRANCHES, References: references}
+		if err := manifest.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	squash := MultiMergeReferenceOptions{Squash: true}
+
+	t.Run("reorders", func(t *testing.T) {
+		saveManifest(
+			MultiMergeReference{Name: "feature-c"},
+			MultiMergeReference{Name: "feature-a", Options: squash, Merged: true},
+			MultiMergeReference{Name: "feature-b"},
+		)
+
+		result, err := repo.MultiMergeReorder(nil, true)
+		if err != nil {
+			t.Fatalf("MultiMergeReorder() error = %v", err)
+		}
+		want := []string{"feature-c", "feature-b", "feature-a"}
+		if !result.Found || !result.Changed || !slices.Equal(result.Order, want) {
+			t.Fatalf("result = %+v, want order %v", result, want)
+		}
+
+		manifest, err := LoadMultiMergeManifest("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, reference := range manifest.References {
+			if reference.Name != want[i] || reference.Merged {
+				t.Errorf("reference %d = %+v, want unmerged %s", i, reference, want[i])
+			}
+		}
+	})
+
+	t.Run("without rewriting", func(t *testing.T) {
+		saveManifest(
+			MultiMergeReference{Name: "feature-c"},
+			MultiMergeReference{Name: "feature-a", Options: squash, Merged: true},
+			MultiMergeReference{Name: "feature-b"},
+		)
+
+		result, err := repo.MultiMergeReorder(nil, false)
+		if err != nil {
+			t.Fatalf("MultiMergeReorder() error = %v", err)
+		}
+		if !result.Found || !result.Changed {
+			t.Fatalf("result = %+v, want a changed order", result)
+		}
+
+		// The manifest is left as it was
+		manifest, err := LoadMultiMergeManifest("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.References[1].Name != "feature-a" || !manifest.References[1].Merged {
+			t.Errorf("references = %+v, want merged feature-a second", manifest.References)
+		}
+	})
+
+	t.Run("pinned", func(t *testing.T) {
+		saveManifest(
+			MultiMergeReference{Name: "feature-a", Options: squash},
+			MultiMergeReference{Name: "feature-c"},
+			MultiMergeReference{Name: "feature-b"},
+		)
+
+		result, err := repo.MultiMergeReorder([]string{"feature-a"}, true)
+		if err != nil {
+			t.Fatalf("MultiMergeReorder() error = %v", err)
+		}
+		if result.Found || len(result.ConflictingPairs) > 0 {
+			t.Errorf("result = %+v, want no order and no conflicting pairs", result)
+		}
+
+		// The manifest is left as it was
+		manifest, err := LoadMultiMergeManifest("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.References[0].Name != "feature-a" || manifest.References[0].Pinned {
+			t.Errorf("references = %+v, want feature-a first and not pinned", manifest.References)
+		}
+	})
+
+	t.Run("octopus group", func(t *testing.T) {
+		octopus := MultiMergeReferenceOptions{Strategy: MULTI_MERGE_STRATEGY_OCTOPUS}
+		saveManifest(
+			MultiMergeReference{Name: "feature-a", Options: squash},
+			MultiMergeReference{Name: "feature-b", Options: octopus},
+			MultiMergeReference{Name: "feature-c", Options: octopus},
+		)
+
+		// feature-b, feature-a, feature-c would merge cleanly too, but splits the group
+		result, err := repo.MultiMergeReorder(nil, false)
+		if err != nil {
+			t.Fatalf("MultiMergeReorder() error = %v", err)
+		}
+		want := []string{"feature-b", "feature-c", "feature-a"}
+		if !result.Found || !slices.Equal(result.Order, want) {
+			t.Errorf("result = %+v, want order %v", result, want)
+		}
+	})
+
+	t.Run("conflicting pair", func(t *testing.T) {
+		saveManifest(
+			MultiMergeReference{Name: "feature-a"},
+			MultiMergeReference{Name: "feature-c"},
+			MultiMergeReference{Name: "feature-d"},
+		)
+
+		result, err := repo.MultiMergeReorder(nil, true)
+		if err != nil {
+			t.Fatalf("MultiMergeReorder() error = %v", err)
+		}
+		if result.Found || len(result.ConflictingPairs) != 1 {
+			t.Fatalf("result = %+v, want no order and one conflicting pair", result)
+		}
+		if pair := result.ConflictingPairs[0]; pair.First != "feature-a" || pair.Second != "feature-d" {
+			t.Errorf("conflicting pair = %+v, want feature-a and feature-d", pair)
+		}
+	})
+
+	t.Run("unknown pin", func(t *testing.T) {
+		if _, err := repo.MultiMergeReorder([]string{"feature-x"}, true); err == nil {
+			t.Error("MultiMergeReorder() error = nil, want error for branch not in the manifest")
+		}
+	})
+}
+
+func TestSearchMergeOrder_StrategyOptions(t *testing.T) {
+	repo := newTestRepository(t)
+	if _, err := repo.ExecuteGitCommandQuiet("merge-tree", "--write-tree", "-X", "theirs", "main", "main"); err != nil {
+		t.Skip("git merge-tree does not support -X")
+	}
+
+	// feature-a and feature-b both change the same line, theirs makes the last one win. feature-c is built on
+	// feature-b, so it only merges cleanly when feature-b was merged last.
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "shared.txt", "a")
+	gitCommand(t, "checkout", "-q", "-b", "feature-b", "main")
+	commitFile(t, "shared.txt", "b")
+	gitCommand(t, "checkout", "-q", "-b", "feature-c")
+	commitFile(t, "shared.txt", "c")
+	gitCommand(t, "checkout", "-q", "main")
+
+	theirs := MultiMergeReferenceOptions{StrategyOptions: []string{"theirs"}}
+	manifest := &MultiMergeManifest{
+		Target: "staging",
+		Type:   MULTI_MERGE_MANIFEST_TYPE_BRANCHES,
+		References: []MultiMergeReference{
+			{Name: "feature-b", Options: theirs},
+			{Name: "feature-a", Options: theirs},
+			{Name: "feature-c", Pinned: true},
+		},
+	}
+	branchNames := []string{"feature-b", "feature-a", "feature-c"}
+	conflictsAfter := [][]bool{make([]bool, 3), make([]bool, 3), make([]bool, 3)}
+
+	// feature-b then feature-a fails, which must not rule out feature-a then feature-b
+	baseSha := gitCommand(t, "rev-parse", "main")
+	order, err := repo.searchMergeOrder(manifest, baseSha, branchNames, conflictsAfter)
+	if err != nil {
+		t.Fatalf("searchMergeOrder() error = %v", err)
+	}
+	if want := []int{1, 0, 2}; !slices.Equal(order, want) {
+		t.Errorf("searchMergeOrder() = %v, want %v", order, want)
+	}
+}
+
+func TestMultiMergeBisect(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for _, name := range []string{"feature-a", "feature-b", "feature-c", "feature-d"} {
+		gitCommand(t, "checkout", "-q", "-b", name, "main")
+		commitFile(t, name+".txt", name)
+	}
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b", "feature-c", "feature-d")
+
+	manifest := &MultiMergeManifest{Target: "staging", Type: MULTI_MERGE_MANIFEST_TYPE_BRANCHES}
+	for _, name := range []string{"feature-a", "feature-b", "feature-c", "feature-d"} {
+		manifest.References = append(manifest.References, MultiMergeReference{Name: name})
+	}
+	if err := manifest.Save(); err != nil {
+		t.Fatal(err)
+	}
+	gitCommand(t, "add", MULTI_MERGE_MANIFEST_FILENAME)
+	gitCommand(t, "commit", "-q", "-m", "Add manifest")
+
+	tests := []struct {
+		name     string
+		command  string
+		culprit  string
+		lastGood []string
+		baseBad  bool
+	}{
+		{"culprit", "test ! -e feature-c.txt", "feature-c", []string{"feature-a", "feature-b"}, false},
+		{"first branch", "test ! -e feature-a.txt", "feature-a", []string{}, false},
+		{"all good", "true", "", []string{"feature-a", "feature-b", "feature-c", "feature-d"}, false},
+		{"base bad", "false", "", []string{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := repo.MultiMergeBisect(tt.command, false)
+			if err != nil {
+				t.Fatalf("MultiMergeBisect() error = %v", err)
+			}
+			if result.Culprit != tt.culprit || result.BaseBad != tt.baseBad || !slices.Equal(result.LastGood, tt.lastGood) {
+				t.Errorf("result = %+v, want culprit %q, last good %v, base bad %v", result, tt.culprit, tt.lastGood, tt.baseBad)
+			}
+
+			// The checkout is untouched and the scratch worktree is gone
+			if branch := gitCommand(t, "branch", "--show-current"); branch != "main" {
+				t.Errorf("checked out branch = %s, want main", branch)
+			}
+			if status := gitCommand(t, "status", "--porcelain"); status != "" {
+				t.Errorf("status = %q, want clean", status)
+			}
+			if worktrees := gitCommand(t, "worktree", "list", "--porcelain"); strings.Count(worktrees, "worktree ") != 1 {
+				t.Errorf("worktrees = %s, want only the main worktree", worktrees)
+			}
+		})
+	}
+}
+
+func TestMultiMergeNamedBranches_Verify(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for _, name := range []string{"feature-a", "feature-b", "feature-c"} {
+		gitCommand(t, "checkout", "-q", "-b", name, "main")
+		commitFile(t, name+".txt", name)
+	}
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b", "feature-c")
+
+	options := MultiMergeOptions{Verify: "test ! -e feature-b.txt"}
+	manifest, err := repo.MultiMergeNamedBranches("nightly", []string{"feature-a", "feature-b", "feature-c"}, options)
+
+	var verifyErr *MultiMergeVerifyError
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("MultiMergeNamedBranches() error = %v, want MultiMergeVerifyError", err)
+	}
+	if verifyErr.BranchName != "feature-b" || verifyErr.Command != options.Verify {
+		t.Errorf("error = %+v, want feature-b failing %s", verifyErr, options.Verify)
+	}
+	if reference := manifest.References[1]; !reference.Merged || !reference.VerifyFailed {
+		t.Errorf("feature-b = %+v, want merged with verify failed", reference)
+	}
+	if manifest.References[2].Merged {
+		t.Error("feature-c was merged after the verify command failed")
+	}
+
+	// Continuing without a fix fails again
+	if _, err := repo.MultiMergeNamedContinue(); !errors.As(err, &verifyErr) {
+		t.Fatalf("MultiMergeNamedContinue() error = %v, want MultiMergeVerifyError", err)
+	}
+
+	gitCommand(t, "rm", "-q", "feature-b.txt")
+	gitCommand(t, "commit", "-q", "-m", "Fix feature-b")
+
+	manifest, err = repo.MultiMergeNamedContinue()
+	if err != nil {
+		t.Fatalf("MultiMergeNamedContinue() error = %v", err)
+	}
+	if !manifest.IsDone() || manifest.References[1].VerifyFailed {
+		t.Errorf("references = %+v, want all merged and verified", manifest.References)
+	}
+}
+
+func TestMultiMergeStatus(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for _, name := range []string{"feature-a", "feature-b", "feature-c"} {
+		gitCommand(t, "checkout", "-q", "-b", name, "main")
+		commitFile(t, name+".txt", name)
+	}
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b", "feature-c")
+
+	if _, err := repo.MultiMergeNamedBranches("nightly", []string{"feature-a", "feature-b", "feature-c"}, MultiMergeOptions{}); err != nil {
+		t.Fatalf("MultiMergeNamedBranches() error = %v", err)
+	}
+
+	result, err := repo.MultiMergeStatus(true)
+	if err != nil {
+		t.Fatalf("MultiMergeStatus() error = %v", err)
+	}
+	if result.Stale() {
+		t.Errorf("result = %+v, want up to date right after merging", result)
+	}
+
+	// feature-a moves on, feature-b is merged into main and deleted
+	gitCommand(t, "checkout", "-q", "feature-a")
+	commitFile(t, "feature-a.txt", "feature-a again")
+	gitCommand(t, "push", "-q", "origin", "feature-a")
+	gitCommand(t, "checkout", "-q", "main")
+	gitCommand(t, "merge", "-q", "--no-edit", "feature-b")
+	gitCommand(t, "push", "-q", "origin", "main", ":feature-b")
+	gitCommand(t, "checkout", "-q", "nightly")
+
+	result, err = repo.MultiMergeStatus(true)
+	if err != nil {
+		t.Fatalf("MultiMergeStatus() error = %v", err)
+	}
+	if result.BaseAhead != 1 || !result.Stale() {
+		t.Errorf("base ahead = %d, stale = %v, want 1 and stale", result.BaseAhead, result.Stale())
+	}
+
+	statuses := map[string]MultiMergeReferenceStatus{}
+	for _, status := range result.References {
+		statuses[status.Name] = status
+	}
+	if status := statuses["feature-a"]; status.Ahead != 1 || status.Deleted || status.MergedToBase {
+		t.Errorf("feature-a = %+v, want 1 ahead", status)
+	}
+	if status := statuses["feature-b"]; !status.Deleted || !status.MergedToBase || status.MergedSha == "" {
+		t.Errorf("feature-b = %+v, want deleted and merged into the base", status)
+	}
+	if status := statuses["feature-c"]; status.Ahead != 0 || status.Deleted || status.MergedToBase || status.RemoteSha != status.MergedSha {
+		t.Errorf("feature-c = %+v, want up to date", status)
+	}
+}
+
+func TestMultiMergeStatus_Rewritten(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "feature-a.txt", "feature-a")
+	commitFile(t, "feature-a.txt", "feature-a again")
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a")
+
+	if _, err := repo.MultiMergeNamedBranches("nightly", []string{"feature-a"}, MultiMergeOptions{}); err != nil {
+		t.Fatalf("MultiMergeNamedBranches() error = %v", err)
+	}
+
+	// Reset to its parent, the branch has no commits that were not merged, but lost one that was
+	gitCommand(t, "push", "-q", "--force", "origin", "feature-a^:feature-a")
+
+	result, err := repo.MultiMergeStatus(true)
+	if err != nil {
+		t.Fatalf("MultiMergeStatus() error = %v", err)
+	}
+	if status := result.References[0]; status.Ahead != 0 || status.Behind != 1 || status.Deleted {
+		t.Errorf("feature-a = %+v, want 1 behind", status)
+	}
+	if !result.Stale() {
+		t.Error("Stale() = false, want true after the branch was reset")
+	}
+}
+
+func TestMultiMergeWatchTick(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for _, name := range []string{"feature-a", "feature-b"} {
+		gitCommand(t, "checkout", "-q", "-b", name, "main")
+		commitFile(t, name+".txt", name)
+	}
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b")
+
+	hookDir := PILA_HOOK_DIRECTORY
+	if err := os.MkdirAll(hookDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	hookScript := "#!/bin/sh\necho \"$1 $2\" > hook-args.txt\n"
+	if err := os.WriteFile(filepath.Join(hookDir, PILA_HOOK_MULTI_MERGE_FAILED), []byte(hookScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.MultiMergeNamedBranches("nightly", []string{"feature-a", "feature-b"}, MultiMergeOptions{}); err != nil {
+		t.Fatalf("MultiMergeNamedBranches() error = %v", err)
+	}
+	builtSha := gitCommand(t, "rev-parse", "nightly")
+
+	// updateFeatureB pushes a change to feature-b, and goes back to the target
+	updateFeatureB := func(change func()) {
+		t.Helper()
+		gitCommand(t, "checkout", "-q", "feature-b")
+		change()
+		gitCommand(t, "push", "-q", "origin", "feature-b")
+		gitCommand(t, "checkout", "-q", "nightly")
+	}
+
+	inputs, rebuilt, err := repo.MultiMergeWatchTick(nil, false)
+	if err != nil || rebuilt {
+		t.Fatalf("MultiMergeWatchTick() = %v, %v, want no rebuild when nothing changed", rebuilt, err)
+	}
+
+	// A conflict puts the target back, and is reported through the hook
+	updateFeatureB(func() { commitFile(t, "feature-a.txt", "feature-b") })
+	inputs, rebuilt, err = repo.MultiMergeWatchTick(inputs, false)
+	var conflictErr *MultiMergeConflictError
+	if !errors.As(err, &conflictErr) || rebuilt {
+		t.Fatalf("MultiMergeWatchTick() = %v, %v, want a conflict", rebuilt, err)
+	}
+	if repo.MergeInProgress() {
+		t.Error("the conflicting merge was left in progress")
+	}
+	if sha := gitCommand(t, "rev-parse", "nightly"); sha != builtSha {
+		t.Errorf("nightly = %s, want the last build %s", sha, builtSha)
+	}
+	if status := gitCommand(t, "status", "--porcelain", "--untracked-files=no"); status != "" {
+		t.Errorf("status = %q, want clean", status)
+	}
+	if data, _ := os.ReadFile("hook-args.txt"); string(data) != "nightly feature-b\n" {
+		t.Errorf("hook arguments = %q, want the target and feature-b", data)
+	}
+
+	// Not retried until something changes
+	inputs, rebuilt, err = repo.MultiMergeWatchTick(inputs, false)
+	if err != nil || rebuilt {
+		t.Fatalf("MultiMergeWatchTick() = %v, %v, want no rebuild when nothing changed", rebuilt, err)
+	}
+
+	updateFeatureB(func() {
+		gitCommand(t, "rm", "-q", "feature-a.txt")
+		gitCommand(t, "commit", "-q", "-m", "Stop conflicting with feature-a")
+	})
+	_, rebuilt, err = repo.MultiMergeWatchTick(inputs, false)
+	if err != nil || !rebuilt {
+		t.Fatalf("MultiMergeWatchTick() = %v, %v, want a rebuild", rebuilt, err)
+	}
+	if sha := gitCommand(t, "rev-parse", "nightly"); sha == builtSha {
+		t.Error("nightly was not rebuilt")
+	}
+}
+
+func TestMultiMergePush(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "checkout", "-q", "main")
+	remoteDir := newTestRemote(t, "feature-a")
+
+	// Someone already has a nightly branch with their own work on the remote
+	gitCommand(t, "checkout", "-q", "-b", "their-nightly")
+	commitFile(t, "theirs.txt", "theirs")
+	gitCommand(t, "push", "-q", "origin", "their-nightly:nightly")
+	gitCommand(t, "checkout", "-q", "main")
+	theirSha := gitCommand(t, "rev-parse", "their-nightly")
+
+	if _, err := repo.MultiMergeNamedBranches("nightly", []string{"feature-a"}, MultiMergeOptions{}); err != nil {
+		t.Fatalf("MultiMergeNamedBranches() error = %v", err)
+	}
+	var foreignErr *ForeignCommitsError
+	if err := repo.MultiMergePush(); !errors.As(err, &foreignErr) {
+		t.Fatalf("MultiMergePush() error = %v, want ForeignCommitsError on the first push", err)
+	}
+	if len(foreignErr.Commits) != 1 || !strings.Contains(foreignErr.Commits[0], "Change theirs.txt") {
+		t.Errorf("error = %+v, want the commit on their nightly", foreignErr)
+	}
+	if remoteSha := gitCommand(t, "--git-dir", remoteDir, "rev-parse", "nightly"); remoteSha != theirSha {
+		t.Errorf("remote nightly = %s, want their nightly %s left alone", remoteSha, theirSha)
+	}
+
+	// A branch that only has commits of the base is replaced
+	gitCommand(t, "push", "-q", "--force", "origin", "main:nightly")
+	if err := repo.MultiMergePush(); err != nil {
+		t.Fatalf("MultiMergePush() error = %v", err)
+	}
+	if remoteSha, sha := gitCommand(t, "--git-dir", remoteDir, "rev-parse", "nightly"), gitCommand(t, "rev-parse", "nightly"); remoteSha != sha {
+		t.Errorf("remote nightly = %s, want %s", remoteSha, sha)
+	}
+
+	// Rebuilding and pushing again replaces what pila pushed before
+	if err := repo.MultiMergeUsingManifest(false); err != nil {
+		t.Fatalf("MultiMergeUsingManifest() error = %v", err)
+	}
+	if err := repo.MultiMergePush(); err != nil {
+		t.Fatalf("MultiMergePush() error = %v", err)
+	}
+	pushedSha := gitCommand(t, "--git-dir", remoteDir, "rev-parse", "nightly")
+
+	// Someone else adds a commit to the target branch
+	gitCommand(t, "checkout", "-q", "-b", "hotfix", "nightly")
+	commitFile(t, "hotfix.txt", "hotfix")
+	gitCommand(t, "push", "-q", "origin", "hotfix:nightly")
+	gitCommand(t, "checkout", "-q", "nightly")
+	foreignSha := gitCommand(t, "rev-parse", "hotfix")
+
+	if err := repo.MultiMergeUsingManifest(false); err != nil {
+		t.Fatalf("MultiMergeUsingManifest() error = %v", err)
+	}
+	err := repo.MultiMergePush()
+	if !errors.As(err, &foreignErr) {
+		t.Fatalf("MultiMergePush() error = %v, want ForeignCommitsError", err)
+	}
+	if len(foreignErr.Commits) != 1 || !strings.Contains(foreignErr.Commits[0], "Change hotfix.txt") || foreignErr.RemoteSha != foreignSha {
+		t.Errorf("error = %+v, want the hotfix commit", foreignErr)
+	}
+	if remoteSha := gitCommand(t, "--git-dir", remoteDir, "rev-parse", "nightly"); remoteSha != foreignSha || remoteSha == pushedSha {
+		t.Errorf("remote nightly = %s, want the hotfix %s left alone", remoteSha, foreignSha)
+	}
+}
+
+func TestMultiMergeUsingManifest_Remotes(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// Main lives on upstream, features on origin, which has an older main
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a")
+	commitFile(t, "upstream.txt", "upstream")
+
+	upstreamDir := filepath.Join(t.TempDir(), "upstream.git")
+	gitCommand(t, "init", "-q", "--bare", upstreamDir)
+	gitCommand(t, "remote", "add", "upstream", upstreamDir)
+	gitCommand(t, "push", "-q", "upstream", "main")
+	gitCommand(t, "remote", "set-head", "upstream", "main")
+
+	useRemoteConfig(t)
+
+	manifest, err := repo.MultiMergeNamedBranches("staging", []string{"feature-a"}, MultiMergeOptions{})
+	if err != nil {
+		t.Fatalf("MultiMergeNamedBranches() error = %v", err)
+	}
+	if upstreamSha := gitCommand(t, "rev-parse", "upstream/main"); manifest.MainSha != upstreamSha {
+		t.Errorf("main sha = %s, want upstream/main at %s", manifest.MainSha, upstreamSha)
+	}
+
+	// A branch that only exists locally is reported against the features remote
+	gitCommand(t, "branch", "feature-local", "main")
+	manifest.References = append(manifest.References, MultiMergeReference{Name: "feature-local"})
+	manifest.Save()
+
+	var localOnlyErr *LocalOnlyBranchesError
+	if err := repo.MultiMergeUsingManifest(false); !errors.As(err, &localOnlyErr) {
+		t.Fatalf("MultiMergeUsingManifest() error = %v, want LocalOnlyBranchesError", err)
+	}
+	if localOnlyErr.Remote != "origin" || len(localOnlyErr.BranchNames) != 1 || localOnlyErr.BranchNames[0] != "feature-local" {
+		t.Errorf("LocalOnlyBranchesError = %+v, want feature-local missing from origin", localOnlyErr)
+	}
+}
+
+func TestMultiMergeNamedLabels_BaseRemote(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "checkout", "-q", "-b", "fork-b", "main")
+	commitFile(t, "b.txt", "b")
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t)
+
+	// Merge requests are opened on upstream, which is the only remote with their branches and the head ref of
+	// the fork. The URL has a host, so the forge can be found through it, origin is a plain path.
+	upstreamDir := filepath.Join(t.TempDir(), "upstream.git")
+	gitCommand(t, "init", "-q", "--bare", upstreamDir)
+	gitCommand(t, "remote", "add", "upstream", "file://localhost"+upstreamDir)
+	gitCommand(t, "push", "-q", "upstream", "main", "feature-a", "fork-b:refs/pull/7/head")
+	gitCommand(t, "remote", "set-head", "upstream", "main")
+	gitCommand(t, "branch", "-D", "feature-a", "fork-b")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"number": 5, "title": "Same repo", "html_url": "https://github.com/owner/repo/pull/5", "created_at": "2025-01-01T10:00:00Z",
+			 "labels": [{"name": "staging"}],
+			 "head": {"ref": "feature-a", "repo": {"full_name": "owner/repo"}}, "base": {"ref": "main", "repo": {"full_name": "owner/repo"}}},
+			{"number": 7, "title": "From fork", "html_url": "https://github.com/owner/repo/pull/7", "created_at": "2025-02-01T10:00:00Z",
+			 "labels": [{"name": "staging"}],
+			 "head": {"ref": "fork-b", "repo": {"full_name": "someone/repo"}}, "base": {"ref": "main", "repo": {"full_name": "owner/repo"}}}
+		]`)
+	}))
+	defer server.Close()
+
+	useRemoteConfig(t)
+	core.Config.Set("forge.type", FORGE_TYPE_GITHUB)
+	core.Config.Set("github.api_url", server.URL)
+
+	manifest, err := repo.MultiMergeNamedLabels("staging", []string{"staging"}, MultiMergeOptions{})
+	if err != nil {
+		t.Fatalf("MultiMergeNamedLabels() error = %v", err)
+	}
+
+	names := []string{}
+	for _, reference := range manifest.References {
+		names = append(names, reference.Name)
+	}
+	if !slices.Equal(names, []string{"feature-a", "pull/7"}) {
+		t.Fatalf("references = %v, want [feature-a pull/7]", names)
+	}
+	if branchName := manifest.References[1].SourceBranch; branchName != "fork-b" {
+		t.Errorf("pull/7 source branch = %s, want fork-b", branchName)
+	}
+	if sha := gitCommand(t, "rev-parse", "origin/feature-a"); manifest.References[0].Sha != sha {
+		t.Errorf("feature-a sha = %s, want the branch fetched from upstream at %s", manifest.References[0].Sha, sha)
+	}
+	if sha := gitCommand(t, "rev-parse", "origin/pull/7"); manifest.References[1].Sha != sha {
+		t.Errorf("pull/7 sha = %s, want the head ref fetched from upstream at %s", manifest.References[1].Sha, sha)
+	}
+	for _, filename := range []string{"a.txt", "b.txt"} {
+		gitCommand(t, "cat-file", "-e", "staging:"+filename)
+	}
+}
@@ -0,0 +1,196 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// MultiMergeInputs are the commits a target branch is built from
+type MultiMergeInputs struct {
+	BaseBranch string
+	BaseSha    string
+	Names      []string // Names of the references, in manifest order
+	Shas       []string // Commit of every reference, empty when its branch is gone
+}
+
+// Changes describes what differs between the inputs and the ones they are compared with, nothing when equal
+func (i *MultiMergeInputs) Changes(previous *MultiMergeInputs) []string {
+	changes := []string{}
+	if i.BaseSha != previous.BaseSha {
+		changes = append(changes, fmt.Sprintf("%s moved to %.7s", i.BaseBranch, i.BaseSha))
+	}
+
+	for index, name := range i.Names {
+		previousIndex := slices.Index(previous.Names, name)
+		switch {
+		case previousIndex == -1:
+			changes = append(changes, fmt.Sprintf("%s was added", name))
+		case i.Shas[index] == previous.Shas[previousIndex]:
+			continue
+		case i.Shas[index] == "":
+			changes = append(changes, fmt.Sprintf("%s was deleted", name))
+		default:
+			changes = append(changes, fmt.Sprintf("%s moved to %.7s", name, i.Shas[index]))
+		}
+	}
+	for _, name := range previous.Names {
+		if !slices.Contains(i.Names, name) {
+			changes = append(changes, fmt.Sprintf("%s was removed", name))
+		}
+	}
+
+	return changes
+}
+
+// multiMergeManifestInputs returns the commits the target was last built from, as recorded in the manifest
+func (r *LocalRepository) multiMergeManifestInputs(manifest *MultiMergeManifest) (*MultiMergeInputs, error) {
+	baseBranchName, err := r.multiMergeBaseBranch(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := &MultiMergeInputs{BaseBranch: baseBranchName, BaseSha: manifest.MainSha}
+	for _, reference := range manifest.References {
+		inputs.Names = append(inputs.Names, reference.Name)
+		inputs.Shas = append(inputs.Shas, reference.Sha)
+	}
+
+	return inputs, nil
+}
+
+// multiMergeCurrentInputs fetches the remotes and returns the commits the target would be built from now. For
+// manifests of type labels the merge requests currently labeled are looked up, like MultiMergeUsingManifest does.
+func (r *LocalRepository) multiMergeCurrentInputs(manifest *MultiMergeManifest) (*MultiMergeInputs, error) {
+	current := *manifest
+	if err := r.fetchMultiMergeRemotes(&current); err != nil {
+		return nil, err
+	}
+	if current.Type == MULTI_MERGE_MANIFEST_TYPE_LABELS {
+		references, err := r.labeledReferences(&current)
+		if err != nil {
+			return nil, err
+		}
+		current.References = references
+	}
+	if err := r.fetchReferenceRefs(&current); err != nil {
+		return nil, err
+	}
+
+	baseBranchName, err := r.multiMergeBaseBranch(&current)
+	if err != nil {
+		return nil, err
+	}
+	baseSha, err := r.GetSha(baseBranchName)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := &MultiMergeInputs{BaseBranch: baseBranchName, BaseSha: baseSha}
+	for _, reference := range current.References {
+		inputs.Names = append(inputs.Names, reference.Name)
+		inputs.Shas = append(inputs.Shas, r.ReferenceSha(&current, reference.Name))
+	}
+
+	return inputs, nil
+}
+
+// MultiMergeWatchTick rebuilds the target branch when its inputs changed since previous, the inputs returned by
+// the last tick, or since the build recorded in the manifest when previous is nil. It returns the inputs to
+// compare with next time, and whether the target was rebuilt.
+//
+// When the rebuild stops on a conflict, or a failing verify command, the merge is aborted, the target branch
+// and the manifest are put back as they were, and the multi-merge-failed.sh hook is run with the target, the
+// branch that failed and the error. The inputs are still returned, so the rebuild is only retried once they
+// change again.
+func (r *LocalRepository) MultiMergeWatchTick(previous *MultiMergeInputs, push bool) (*MultiMergeInputs, bool, error) {
+	manifest, err := r.LoadMultiMergeManifest()
+	if err != nil {
+		return previous, false, err
+	}
+	if r.MergeInProgress() || r.SquashInProgress() {
+		return previous, false, errors.New("a merge is currently in progress, please run 'pila multi-merge continue' or 'pila multi-merge abort' first")
+	}
+
+	if previous == nil {
+		previous, err = r.multiMergeManifestInputs(manifest)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	current, err := r.multiMergeCurrentInputs(manifest)
+	if err != nil {
+		return previous, false, err
+	}
+
+	changes := current.Changes(previous)
+	if len(changes) == 0 {
+		r.Note("Nothing changed since the last build of %s", manifest.Target)
+		return current, false, nil
+	}
+	r.Note("Rebuild %s, %s", manifest.Target, strings.Join(changes, ", "))
+
+	// Remember the last build, to go back to if this one fails
+	previousTargetSha, _ := r.GetSha(fmt.Sprintf("refs/heads/%s", manifest.Target))
+	previousManifest, err := os.ReadFile(manifest.Path())
+	if err != nil {
+		return previous, false, err
+	}
+
+	if err := r.MultiMergeUsingManifest(false); err != nil {
+		var conflictErr *MultiMergeConflictError
+		var verifyErr *MultiMergeVerifyError
+		branchName := ""
+		if errors.As(err, &conflictErr) {
+			branchName = conflictErr.BranchName
+		} else if errors.As(err, &verifyErr) {
+			branchName = verifyErr.BranchName
+		} else {
+			return previous, false, err
+		}
+
+		if restoreErr := r.restoreMultiMergeTarget(manifest, previousTargetSha, previousManifest); restoreErr != nil {
+			return current, false, restoreErr
+		}
+		r.RunHook(PILA_HOOK_MULTI_MERGE_FAILED, manifest.Target, branchName, err.Error())
+
+		return current, false, err
+	}
+
+	if push {
+		if err := r.MultiMergePush(); err != nil {
+			return current, true, err
+		}
+	}
+
+	return current, true, nil
+}
+
+// restoreMultiMergeTarget backs out of a stopped multi-merge, and puts the target branch and the manifest back
+// the way they were before it started
+func (r *LocalRepository) restoreMultiMergeTarget(manifest *MultiMergeManifest, targetSha string, manifestData []byte) error {
+	r.Note("Restore %s", manifest.Target)
+
+	// Squash merges leave no MERGE_HEAD to abort, the hard reset takes care of them
+	if r.MergeInProgress() {
+		if output, err := r.ExecuteGitCommand("merge", "--abort"); err != nil {
+			return fmt.Errorf("%s", strings.TrimSpace(output))
+		}
+	}
+
+	if targetSha == "" {
+		// There was no target branch before, start over from the base instead
+		baseBranchName, err := r.multiMergeBaseBranch(manifest)
+		if err != nil {
+			return err
+		}
+		targetSha = baseBranchName
+	}
+	if output, err := r.ExecuteGitCommand("reset", "--hard", targetSha); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	return os.WriteFile(manifest.Path(), manifestData, 0o644)
+}
@@ -0,0 +1,112 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// MultiMergeWorktreePath returns where the worktree of a multi-merge target lives, .git/pila/worktrees/<target>
+func (r *LocalRepository) MultiMergeWorktreePath(target string) (string, error) {
+	worktreesDir, err := r.multiMergeWorktreesDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(worktreesDir, target), nil
+}
+
+func (r *LocalRepository) multiMergeWorktreesDir() (string, error) {
+	commonDir, err := r.GitCommonDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(commonDir, "pila", "worktrees"), nil
+}
+
+// enterMultiMergeWorktree makes git commands run in the worktree of the target, creating the worktree when missing
+func (r *LocalRepository) enterMultiMergeWorktree(target string) error {
+	path, err := r.MultiMergeWorktreePath(target)
+	if err != nil {
+		return err
+	}
+
+	worktrees, err := r.multiMergeWorktrees()
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(worktrees, path) {
+		// Forget worktrees whose directory has been deleted by hand, so the path can be reused
+		r.ExecuteGitCommandQuiet("worktree", "prune")
+
+		r.Note("Create worktree for %s in %s", target, path)
+		output, err := r.ExecuteGitCommand("worktree", "add", "--detach", path)
+		if err != nil {
+			return fmt.Errorf("%s", strings.TrimSpace(output))
+		}
+	}
+
+	r.WorkDir = path
+	return nil
+}
+
+// multiMergeWorktrees lists the worktrees created for multi-merges
+func (r *LocalRepository) multiMergeWorktrees() ([]string, error) {
+	worktreesDir, err := r.multiMergeWorktreesDir()
+	if err != nil {
+		return nil, err
+	}
+	// Git reports worktrees by their real path
+	if realDir, err := filepath.EvalSymlinks(worktreesDir); err == nil {
+		worktreesDir = realDir
+	}
+
+	output, err := r.ExecuteGitCommandQuiet("worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	worktrees := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		path, found := strings.CutPrefix(line, "worktree ")
+		if found && strings.HasPrefix(path, worktreesDir+string(filepath.Separator)) {
+			worktrees = append(worktrees, path)
+		}
+	}
+
+	return worktrees, nil
+}
+
+// LoadMultiMergeManifest loads the manifest from the directory git commands are run in
+func (r *LocalRepository) LoadMultiMergeManifest() (*MultiMergeManifest, error) {
+	return LoadMultiMergeManifest(r.WorkDir)
+}
+
+// MultiMergeWorkDir returns the directory the multi-merge is done in, to be used as WorkDir. That is the current
+// one, returned as empty, when it has a manifest or there are no multi-merge worktrees, and the multi-merge
+// worktree otherwise. An explicit WorkDir is returned as is.
+func (r *LocalRepository) MultiMergeWorkDir() (string, error) {
+	if r.WorkDir != "" {
+		return r.WorkDir, nil
+	}
+	if _, err := os.Stat(MULTI_MERGE_MANIFEST_FILENAME); err == nil {
+		return "", nil
+	}
+
+	worktrees, err := r.multiMergeWorktrees()
+	if err != nil {
+		return "", err
+	}
+	switch len(worktrees) {
+	case 0:
+		return "", nil
+	case 1:
+		return worktrees[0], nil
+	}
+
+	return "", fmt.Errorf("there is no manifest here, and there are several multi-merge worktrees, please run pila in one of them:\n  %s", strings.Join(worktrees, "\n  "))
+}
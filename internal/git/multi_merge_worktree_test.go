@@ -0,0 +1,86 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMultiMergeNamedBranches_Worktree(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "feature-a")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "checkout", "-q", "-b", "feature-b", "main")
+	commitFile(t, "b.txt", "b")
+	gitCommand(t, "checkout", "-q", "main")
+	newTestRemote(t, "feature-a", "feature-b")
+
+	// Uncommitted work in the checkout must survive the multi-merge
+	if err := os.WriteFile("README.md", []byte("work in progress\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := repo.MultiMergeNamedBranches("staging", []string{"feature-a", "feature-b"}, MultiMergeOptions{Worktree: true})
+	if err != nil {
+		t.Fatalf("MultiMergeNamedBranches() error = %v", err)
+	}
+	if !manifest.IsDone() {
+		t.Error("multi-merge is not done")
+	}
+
+	if got := gitCommand(t, "rev-parse", "--abbrev-ref", "HEAD"); got != "main" {
+		t.Errorf("checked out branch = %s, want main", got)
+	}
+	if data, _ := os.ReadFile("README.md"); string(data) != "work in progress\n" {
+		t.Errorf("README.md = %q, uncommitted work was lost", data)
+	}
+	if _, err := os.Stat(MULTI_MERGE_MANIFEST_FILENAME); err == nil {
+		t.Error("manifest was written to the checkout")
+	}
+
+	worktreePath, err := repo.MultiMergeWorktreePath("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gitCommand(t, "-C", worktreePath, "rev-parse", "--abbrev-ref", "HEAD"); got != "staging" {
+		t.Errorf("worktree branch = %s, want staging", got)
+	}
+	for _, filename := range []string{"a.txt", "b.txt", MULTI_MERGE_MANIFEST_FILENAME} {
+		if _, err := os.Stat(filepath.Join(worktreePath, filename)); err != nil {
+			t.Errorf("%s missing from worktree: %v", filename, err)
+		}
+	}
+
+	// A fresh handle, like the next pila command, finds the manifest in the worktree
+	other := &LocalRepository{Type: "unknown", Repository: repo.Repository}
+	workDir, err := other.MultiMergeWorkDir()
+	if err != nil {
+		t.Fatalf("MultiMergeWorkDir() error = %v", err)
+	}
+	if realWorkDir, _ := filepath.EvalSymlinks(workDir); realWorkDir != mustEvalSymlinks(t, worktreePath) {
+		t.Errorf("MultiMergeWorkDir() = %s, want %s", workDir, worktreePath)
+	}
+	if other.WorkDir != "" {
+		t.Errorf("WorkDir = %s, want it left alone", other.WorkDir)
+	}
+
+	other.WorkDir = workDir
+	loaded, err := other.LoadMultiMergeManifest()
+	if err != nil {
+		t.Fatalf("LoadMultiMergeManifest() error = %v", err)
+	}
+	if loaded.Target != "staging" || !loaded.Options.Worktree {
+		t.Errorf("loaded manifest = %+v, want the staging worktree manifest", loaded)
+	}
+}
+
+func mustEvalSymlinks(t *testing.T, path string) string {
+	t.Helper()
+
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return realPath
+}
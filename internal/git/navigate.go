@@ -0,0 +1,110 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AmbiguousChildrenError is returned when moving up from a branch with several branches stacked on top of it
+type AmbiguousChildrenError struct {
+	BranchName string
+	Children   []string
+}
+
+func (e *AmbiguousChildrenError) Error() string {
+	return fmt.Sprintf("%d branches are stacked on top of '%s' (%s), please pick one by index", len(e.Children), e.BranchName, strings.Join(e.Children, ", "))
+}
+
+// Child returns the branch stacked on top of the branch. When there are several, index picks
+// one of them counting from 1, and 0 returns an AmbiguousChildrenError listing them.
+func (g *StackGraph) Child(branchName string, index int) (string, error) {
+	node, ok := g.Nodes[branchName]
+	if !ok {
+		return "", fmt.Errorf("branch '%s' is not part of any stack", branchName)
+	}
+
+	switch {
+	case len(node.Children) == 0:
+		return "", fmt.Errorf("%s is at the top of the stack", branchName)
+	case index > len(node.Children) || index < 0:
+		return "", fmt.Errorf("index %d is out of range, %s has %d branches on top of it", index, branchName, len(node.Children))
+	case index > 0:
+		return node.Children[index-1].Branch, nil
+	case len(node.Children) == 1:
+		return node.Children[0].Branch, nil
+	}
+
+	children := []string{}
+	for _, child := range node.Children {
+		children = append(children, child.Branch)
+	}
+	return "", &AmbiguousChildrenError{BranchName: branchName, Children: children}
+}
+
+// ParentOf returns the branch the branch is stacked on top of
+func (g *StackGraph) ParentOf(branchName string) (string, error) {
+	node, ok := g.Nodes[branchName]
+	if !ok {
+		return "", fmt.Errorf("branch '%s' is not part of any stack", branchName)
+	}
+	if node.Parent == nil {
+		return "", fmt.Errorf("%s is at the bottom of the stack", branchName)
+	}
+
+	return node.Parent.Branch, nil
+}
+
+// Top follows the children of the branch to the top of the stack. Index picks the child
+// at the first branch with several children, any further fork is an AmbiguousChildrenError.
+func (g *StackGraph) Top(branchName string, index int) (string, error) {
+	node, ok := g.Nodes[branchName]
+	if !ok {
+		return "", fmt.Errorf("branch '%s' is not part of any stack", branchName)
+	}
+	if len(node.Children) == 0 {
+		return "", fmt.Errorf("%s is at the top of the stack", branchName)
+	}
+
+	for len(node.Children) > 0 {
+		// Single children are followed as they are, the index is spent on the first fork
+		childIndex := 0
+		if len(node.Children) > 1 {
+			childIndex, index = index, 0
+		}
+
+		childName, err := g.Child(node.Branch, childIndex)
+		if err != nil {
+			return "", err
+		}
+		node = g.Nodes[childName]
+	}
+
+	return node.Branch, nil
+}
+
+// Bottom returns the lowest branch below the branch, the one stacked directly on top of main
+func (g *StackGraph) Bottom(branchName string) (string, error) {
+	node, ok := g.Nodes[branchName]
+	if !ok {
+		return "", fmt.Errorf("branch '%s' is not part of any stack", branchName)
+	}
+	if node.Parent == nil {
+		return "", fmt.Errorf("%s is not part of a stack, please checkout a stack branch first", branchName)
+	}
+
+	for node.Parent.Parent != nil {
+		node = node.Parent
+	}
+
+	return node.Branch, nil
+}
+
+// CheckoutBranch checks out an existing local branch
+func (r *LocalRepository) CheckoutBranch(branchName string) error {
+	output, err := r.ExecuteGitCommand("checkout", branchName)
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package git
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// newTestStackGraph builds main <- a <- b <- c, with d also on top of a
+func newTestStackGraph() *StackGraph {
+	graph := &StackGraph{Nodes: map[string]*StackNode{}}
+	add := func(branchName, parentName string) {
+		node := &StackNode{Branch: branchName, Parent: graph.Nodes[parentName]}
+		if node.Parent == nil {
+			graph.Root = node
+		} else {
+			node.Parent.Children = append(node.Parent.Children, node)
+		}
+		graph.Nodes[branchName] = node
+	}
+	add("main", "")
+	add("a", "main")
+	add("b", "a")
+	add("c", "b")
+	add("d", "a")
+
+	return graph
+}
+
+func TestStackGraph_Navigation(t *testing.T) {
+	graph := newTestStackGraph()
+
+	tests := []struct {
+		name string
+		move func() (string, error)
+		want string
+	}{
+		{"up from b", func() (string, error) { return graph.Child("b", 0) }, "c"},
+		{"up from a picking d", func() (string, error) { return graph.Child("a", 2) }, "d"},
+		{"down from c", func() (string, error) { return graph.ParentOf("c") }, "b"},
+		{"down from a", func() (string, error) { return graph.ParentOf("a") }, "main"},
+		{"top from a picking b", func() (string, error) { return graph.Top("a", 1) }, "c"},
+		{"top from main picking d", func() (string, error) { return graph.Top("main", 2) }, "d"},
+		{"top from b", func() (string, error) { return graph.Top("b", 0) }, "c"},
+		{"bottom from c", func() (string, error) { return graph.Bottom("c") }, "a"},
+		{"bottom from a", func() (string, error) { return graph.Bottom("a") }, "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.move()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStackGraph_Navigation_Errors(t *testing.T) {
+	graph := newTestStackGraph()
+
+	var ambiguousErr *AmbiguousChildrenError
+	if _, err := graph.Top("a", 0); !errors.As(err, &ambiguousErr) {
+		t.Fatalf("Top() error = %v, want AmbiguousChildrenError", err)
+	}
+	if !slices.Equal(ambiguousErr.Children, []string{"b", "d"}) {
+		t.Errorf("children = %v, want [b d]", ambiguousErr.Children)
+	}
+	if _, err := graph.Top("main", 0); !errors.As(err, &ambiguousErr) || ambiguousErr.BranchName != "a" {
+		t.Errorf("Top() below the fork error = %v, want AmbiguousChildrenError at a", err)
+	}
+
+	if _, err := graph.Child("c", 0); err == nil {
+		t.Error("Child() at the top expected error, got nil")
+	}
+	if _, err := graph.Child("a", 3); err == nil {
+		t.Error("Child() with out of range index expected error, got nil")
+	}
+	if _, err := graph.ParentOf("main"); err == nil {
+		t.Error("ParentOf() main expected error, got nil")
+	}
+	if _, err := graph.Bottom("main"); err == nil {
+		t.Error("Bottom() of main expected error, got nil")
+	}
+}
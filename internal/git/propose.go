@@ -0,0 +1,173 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The stack table is kept between these markers in every merge request description,
+// so it can be replaced without touching what the author wrote around it
+const (
+	STACK_TABLE_START_MARKER = "<!-- pila-stack -->"
+	STACK_TABLE_END_MARKER   = "<!-- /pila-stack -->"
+)
+
+// ProposeResult is the merge request of a single stack branch
+type ProposeResult struct {
+	BranchName   string
+	MergeRequest *MergeRequest
+	Status       string // PUBLISH_STATUS_CREATED, PUBLISH_STATUS_UPDATED or PUBLISH_STATUS_UP_TO_DATE
+}
+
+// ProposeStack creates or updates a merge request for every branch in the stack of the checked out branch.
+// Each merge request targets the parent branch, and its description gets a table linking the whole stack.
+// The branches must have been published first, the merge requests are opened on the forge they were pushed to.
+func (r *LocalRepository) ProposeStack() ([]ProposeResult, error) {
+	mainBranchName, err := r.MainBranchName()
+	if err != nil {
+		return nil, err
+	}
+
+	checkedOutBranchName, err := r.CheckedOutBranchName()
+	if err != nil {
+		return nil, err
+	}
+	if checkedOutBranchName == mainBranchName {
+		return nil, fmt.Errorf("%s is not part of a stack, please checkout a stack branch first", mainBranchName)
+	}
+
+	stack, err := r.GetBranchStack(mainBranchName, checkedOutBranchName)
+	if err != nil {
+		return nil, err
+	}
+
+	forge, err := r.Forge()
+	if err != nil {
+		return nil, err
+	}
+
+	// Find or create the merge requests first, the stack table needs all of them
+	results := []ProposeResult{}
+	mergeRequests := map[string]*MergeRequest{}
+	var walkErr error
+	stack.Walk(func(node *StackNode, depth int) {
+		if node.Parent == nil || walkErr != nil {
+			return
+		}
+
+		mergeRequest, err := forge.OpenMergeRequestForBranch(node.Branch)
+		if err != nil {
+			walkErr = err
+			return
+		}
+
+		status := PUBLISH_STATUS_UP_TO_DATE
+		if mergeRequest == nil {
+			r.Note("Create merge request for %s onto %s", node.Branch, node.Parent.Branch)
+			mergeRequest, err = forge.CreateMergeRequest(MergeRequestOptions{
+				SourceBranch: node.Branch,
+				TargetBranch: node.Parent.Branch,
+				Title:        r.mergeRequestTitle(node.Branch, node.Parent.Branch),
+			})
+			if err != nil {
+				walkErr = err
+				return
+			}
+			status = PUBLISH_STATUS_CREATED
+		}
+
+		mergeRequests[node.Branch] = mergeRequest
+		results = append(results, ProposeResult{BranchName: node.Branch, MergeRequest: mergeRequest, Status: status})
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	for i := range results {
+		result := &results[i]
+		parentName := stack.Nodes[result.BranchName].Parent.Branch
+
+		description := withStackTable(result.MergeRequest.Description, stackTable(stack, mergeRequests, result.BranchName))
+		if description == result.MergeRequest.Description && result.MergeRequest.TargetBranch == parentName {
+			continue
+		}
+
+		r.Note("Update merge request #%d for %s", result.MergeRequest.Number, result.BranchName)
+		updated, err := forge.UpdateMergeRequest(result.MergeRequest.Number, MergeRequestOptions{
+			TargetBranch: parentName,
+			Description:  description,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		result.MergeRequest = updated
+		if result.Status != PUBLISH_STATUS_CREATED {
+			result.Status = PUBLISH_STATUS_UPDATED
+		}
+	}
+
+	return results, nil
+}
+
+// mergeRequestTitle uses the subject of the first commit on the branch, like the forges do in their web interfaces
+func (r *LocalRepository) mergeRequestTitle(branchName, parentName string) string {
+	output, err := r.ExecuteGitCommandQuiet("log", "--reverse", "--format=%s", fmt.Sprintf("%s..%s", parentName, branchName))
+	if err != nil || output == "" {
+		return branchName
+	}
+
+	subject, _, _ := strings.Cut(output, "\n")
+	return subject
+}
+
+// stackTable renders the stack as a markdown table linking the merge requests, marking the one of the current branch
+func stackTable(stack *StackGraph, mergeRequests map[string]*MergeRequest, currentBranchName string) string {
+	var table strings.Builder
+	table.WriteString(STACK_TABLE_START_MARKER + "\n")
+	table.WriteString("**Stack**\n\n")
+	table.WriteString("| | Merge request | Branch | Based on |\n")
+	table.WriteString("| --- | --- | --- | --- |\n")
+
+	stack.Walk(func(node *StackNode, depth int) {
+		if node.Parent == nil {
+			return
+		}
+
+		here := ""
+		if node.Branch == currentBranchName {
+			here = "👉"
+		}
+
+		link := ""
+		if mergeRequest, ok := mergeRequests[node.Branch]; ok {
+			link = fmt.Sprintf("[#%d %s](%s)", mergeRequest.Number, escapeTableCell(mergeRequest.Title), mergeRequest.URL)
+		}
+
+		fmt.Fprintf(&table, "| %s | %s | `%s` | `%s` |\n", here, link, node.Branch, node.Parent.Branch)
+	})
+
+	table.WriteString(STACK_TABLE_END_MARKER)
+
+	return table.String()
+}
+
+// withStackTable replaces the stack table in a description, or appends it when there is none yet
+func withStackTable(description, table string) string {
+	start := strings.Index(description, STACK_TABLE_START_MARKER)
+	end := strings.Index(description, STACK_TABLE_END_MARKER)
+	if start != -1 && end > start {
+		return description[:start] + table + description[end+len(STACK_TABLE_END_MARKER):]
+	}
+
+	description = strings.TrimRight(description, "\n")
+	if description == "" {
+		return table
+	}
+
+	return description + "\n\n" + table
+}
+
+func escapeTableCell(text string) string {
+	return strings.ReplaceAll(text, "|", `\|`)
+}
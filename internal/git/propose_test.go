@@ -0,0 +1,177 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.olrik.dev/pila/internal/core"
+)
+
+func TestWithStackTable(t *testing.T) {
+	table := STACK_TABLE_START_MARKER + "\nnew table\n" + STACK_TABLE_END_MARKER
+
+	tests := []struct {
+		name        string
+		description string
+		want        string
+	}{
+		{"empty", "", table},
+		{"append", "Adds the API.\n", "Adds the API.\n\n" + table},
+		{
+			"replace",
+			"Adds the API.\n\n" + STACK_TABLE_START_MARKER + "\nold table\n" + STACK_TABLE_END_MARKER + "\n\nMore notes",
+			"Adds the API.\n\n" + table + "\n\nMore notes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withStackTable(tt.description, table); got != tt.want {
+				t.Errorf("withStackTable() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakePullRequests is a minimal in-memory GitHub pulls API
+type fakePullRequests struct {
+	mu      sync.Mutex
+	pulls   map[int]*githubPullRequest
+	creates int
+}
+
+func (f *fakePullRequests) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var payload map[string]string
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/pulls":
+		pulls := []*githubPullRequest{}
+		for _, pr := range f.pulls {
+			if "owner:"+pr.Head.Ref == r.URL.Query().Get("head") {
+				pulls = append(pulls, pr)
+			}
+		}
+		json.NewEncoder(w).Encode(pulls)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/pulls":
+		f.creates++
+		number := len(f.pulls) + 1
+		repo := &githubRepository{FullName: "owner/repo"}
+		f.pulls[number] = &githubPullRequest{
+			Number:  number,
+			Title:   payload["title"],
+			Body:    payload["body"],
+			HTMLURL: fmt.Sprintf("https://github.com/owner/repo/pull/%d", number),
+			Head:    githubBranch{Ref: payload["head"], Repo: repo},
+			Base:    githubBranch{Ref: payload["base"], Repo: repo},
+		}
+		json.NewEncoder(w).Encode(f.pulls[number])
+
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/repos/owner/repo/pulls/"):
+		var number int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/pulls/"), "%d", &number)
+		pr, ok := f.pulls[number]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		pr.Base.Ref = payload["base"]
+		pr.Body = payload["body"]
+		json.NewEncoder(w).Encode(pr)
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestProposeStack(t *testing.T) {
+	repo := newTestRepository(t)
+	gitCommand(t, "remote", "add", "origin", "https://github.com/owner/repo.git")
+	gitCommand(t, "update-ref", "refs/remotes/origin/main", "main")
+	gitCommand(t, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/main")
+
+	gitCommand(t, "checkout", "-q", "-b", "api")
+	commitFile(t, "api.txt", "api")
+	if err := repo.CreateStackBranch("ui"); err != nil {
+		t.Fatalf("CreateStackBranch() error = %v", err)
+	}
+	commitFile(t, "ui.txt", "ui")
+
+	fake := &fakePullRequests{pulls: map[int]*githubPullRequest{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	previousConfig := core.Config
+	t.Cleanup(func() { core.Config = previousConfig })
+	core.Config = viper.New()
+	core.Config.Set("forge.type", FORGE_TYPE_GITHUB)
+	core.Config.Set("github.api_url", server.URL)
+
+	results, err := repo.ProposeStack()
+	if err != nil {
+		t.Fatalf("ProposeStack() error = %v", err)
+	}
+	if len(results) != 2 || results[0].Status != PUBLISH_STATUS_CREATED || results[1].Status != PUBLISH_STATUS_CREATED {
+		t.Fatalf("ProposeStack() = %+v, want api and ui created", results)
+	}
+
+	api, ui := fake.pulls[results[0].MergeRequest.Number], fake.pulls[results[1].MergeRequest.Number]
+	if api.Base.Ref != "main" || ui.Base.Ref != "api" {
+		t.Errorf("targets = %s and %s, want main and api", api.Base.Ref, ui.Base.Ref)
+	}
+	if api.Title != "Change api.txt" {
+		t.Errorf("title = %q, want the first commit subject", api.Title)
+	}
+	if !strings.Contains(ui.Body, "| 👉 | [#2 Change ui.txt](https://github.com/owner/repo/pull/2) | `ui` | `api` |") ||
+		!strings.Contains(ui.Body, "|  | [#1 Change api.txt](https://github.com/owner/repo/pull/1) | `api` | `main` |") {
+		t.Errorf("ui description does not link the stack:\n%s", ui.Body)
+	}
+
+	// The author adds to the description, and the stack gets a new branch
+	api.Body = "Adds the API.\n\n" + api.Body
+	if err := repo.CreateStackBranch("tests"); err != nil {
+		t.Fatalf("CreateStackBranch() error = %v", err)
+	}
+	commitFile(t, "tests.txt", "tests")
+
+	results, err = repo.ProposeStack()
+	if err != nil {
+		t.Fatalf("ProposeStack() error = %v", err)
+	}
+	if fake.creates != 3 {
+		t.Errorf("created %d pull requests, want 3", fake.creates)
+	}
+	statuses := []string{}
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	if got := strings.Join(statuses, ","); got != "updated,updated,created" {
+		t.Errorf("statuses = %s, want updated,updated,created", got)
+	}
+	if !strings.HasPrefix(api.Body, "Adds the API.\n\n"+STACK_TABLE_START_MARKER) || !strings.Contains(api.Body, "`tests`") {
+		t.Errorf("api description not updated in place:\n%s", api.Body)
+	}
+
+	// Nothing changed, nothing to update
+	results, err = repo.ProposeStack()
+	if err != nil {
+		t.Fatalf("ProposeStack() error = %v", err)
+	}
+	for _, result := range results {
+		if result.Status != PUBLISH_STATUS_UP_TO_DATE {
+			t.Errorf("%s is %s, want up to date", result.BranchName, result.Status)
+		}
+	}
+}
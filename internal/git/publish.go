@@ -0,0 +1,132 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+const (
+	PUBLISH_STATUS_CREATED    = "created"
+	PUBLISH_STATUS_UPDATED    = "updated"
+	PUBLISH_STATUS_UP_TO_DATE = "up to date"
+	PUBLISH_STATUS_REJECTED   = "rejected"
+)
+
+// PublishResult is the outcome of pushing a single branch
+type PublishResult struct {
+	BranchName string
+	Status     string
+	Reason     string // Why the push was rejected, as reported by git
+}
+
+// StackBranchNames returns the branches of the stack the checked out branch is part of,
+// parents before children, without the main branch
+func (r *LocalRepository) StackBranchNames() ([]string, error) {
+	mainBranchName, err := r.MainBranchName()
+	if err != nil {
+		return nil, err
+	}
+
+	checkedOutBranchName, err := r.CheckedOutBranchName()
+	if err != nil {
+		return nil, err
+	}
+	if checkedOutBranchName == mainBranchName {
+		return nil, fmt.Errorf("%s is not part of a stack, please checkout a stack branch first", mainBranchName)
+	}
+
+	stack, err := r.GetBranchStack(mainBranchName, checkedOutBranchName)
+	if err != nil {
+		return nil, err
+	}
+
+	return stack.Branches()[1:], nil
+}
+
+// PublishStack pushes every branch in the stack of the checked out branch to the features remote
+// and sets their upstreams. Each branch is pushed with a lease on the sha of its remote tracking branch,
+// so the push is rejected if someone else pushed to the branch since it was last fetched.
+func (r *LocalRepository) PublishStack() ([]PublishResult, error) {
+	branchNames, err := r.StackBranchNames()
+	if err != nil {
+		return nil, err
+	}
+
+	featuresRemote := r.FeaturesRemote()
+	pushArgs := []string{"push", "--porcelain", "--set-upstream", featuresRemote}
+	for _, branchName := range branchNames {
+		// An empty lease means the branch must not exist on the remote yet
+		expectedSha, _ := r.GetSha(fmt.Sprintf("refs/remotes/%s/%s", featuresRemote, branchName))
+		pushArgs = append(pushArgs, fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", branchName, expectedSha))
+	}
+	for _, branchName := range branchNames {
+		pushArgs = append(pushArgs, fmt.Sprintf("refs/heads/%s:refs/heads/%s", branchName, branchName))
+	}
+
+	r.Note("Publish %s", strings.Join(branchNames, ", "))
+	fmt.Println(color.CyanString("$ git %s", strings.Join(pushArgs, " ")))
+
+	// Rejected branches make git exit non-zero, but the porcelain output still has the result of every branch
+	stdout, stderr, pushErr := r.executeGitCommandCapture("", pushArgs...)
+
+	resultsByBranch := map[string]PublishResult{}
+	for _, result := range parsePushPorcelain(stdout) {
+		resultsByBranch[result.BranchName] = result
+	}
+	if len(resultsByBranch) == 0 {
+		if pushErr != nil {
+			return nil, fmt.Errorf("%s", strings.TrimSpace(stderr))
+		}
+		return nil, errors.New("git push did not report any branches")
+	}
+
+	// Report in stack order, git reports in its own order
+	results := []PublishResult{}
+	for _, branchName := range branchNames {
+		if result, ok := resultsByBranch[branchName]; ok {
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+// parsePushPorcelain parses the output of `git push --porcelain`, one line per pushed ref:
+//
+//	<flag>\t<from>:<to>\t<summary> (<reason>)
+func parsePushPorcelain(output string) []PublishResult {
+	results := []PublishResult{}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 || len(fields[0]) != 1 {
+			continue
+		}
+
+		_, to, _ := strings.Cut(fields[1], ":")
+		result := PublishResult{BranchName: strings.TrimPrefix(to, "refs/heads/")}
+
+		switch fields[0] {
+		case "*":
+			result.Status = PUBLISH_STATUS_CREATED
+		case " ", "+":
+			result.Status = PUBLISH_STATUS_UPDATED
+		case "=":
+			result.Status = PUBLISH_STATUS_UP_TO_DATE
+		case "!":
+			result.Status = PUBLISH_STATUS_REJECTED
+			if start := strings.Index(fields[2], "("); start != -1 {
+				result.Reason = strings.TrimSuffix(fields[2][start+1:], ")")
+			}
+		default:
+			continue
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
@@ -0,0 +1,123 @@
+package git
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.olrik.dev/pila/internal/core"
+)
+
+func TestParsePushPorcelain(t *testing.T) {
+	output := "To /tmp/remote.git\n" +
+		"*\trefs/heads/a:refs/heads/a\t[new branch]\n" +
+		"+\trefs/heads/b:refs/heads/b\t1a2b3c...4d5e6f (forced update)\n" +
+		" \trefs/heads/c:refs/heads/c\t1a2b3c..4d5e6f\n" +
+		"=\trefs/heads/d:refs/heads/d\t[up to date]\n" +
+		"!\trefs/heads/e:refs/heads/e\t[rejected] (stale info)\n" +
+		"branch 'a' set up to track 'origin/a'.\n" +
+		"Done\n"
+
+	want := []PublishResult{
+		{BranchName: "a", Status: PUBLISH_STATUS_CREATED},
+		{BranchName: "b", Status: PUBLISH_STATUS_UPDATED},
+		{BranchName: "c", Status: PUBLISH_STATUS_UPDATED},
+		{BranchName: "d", Status: PUBLISH_STATUS_UP_TO_DATE},
+		{BranchName: "e", Status: PUBLISH_STATUS_REJECTED, Reason: "stale info"},
+	}
+
+	if got := parsePushPorcelain(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePushPorcelain() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPublishStack(t *testing.T) {
+	repo := newTestRepository(t)
+
+	remoteDir := filepath.Join(t.TempDir(), "remote.git")
+	gitCommand(t, "init", "-q", "--bare", remoteDir)
+	gitCommand(t, "remote", "add", "origin", remoteDir)
+	gitCommand(t, "push", "-q", "origin", "main")
+	gitCommand(t, "remote", "set-head", "origin", "main")
+
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a")
+	if err := repo.CreateStackBranch("b"); err != nil {
+		t.Fatalf("CreateStackBranch() error = %v", err)
+	}
+	commitFile(t, "b.txt", "b")
+
+	assertPublish := func(want map[string]string) {
+		t.Helper()
+		results, err := repo.PublishStack()
+		if err != nil {
+			t.Fatalf("PublishStack() error = %v", err)
+		}
+		got := map[string]string{}
+		for _, result := range results {
+			got[result.BranchName] = result.Status
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PublishStack() = %v, want %v", got, want)
+		}
+	}
+
+	assertPublish(map[string]string{"a": PUBLISH_STATUS_CREATED, "b": PUBLISH_STATUS_CREATED})
+	if got := gitCommand(t, "rev-parse", "--abbrev-ref", "b@{upstream}"); got != "origin/b" {
+		t.Errorf("upstream of b = %s, want origin/b", got)
+	}
+
+	assertPublish(map[string]string{"a": PUBLISH_STATUS_UP_TO_DATE, "b": PUBLISH_STATUS_UP_TO_DATE})
+
+	// Rewriting history is fine, as long as nobody else pushed
+	gitCommand(t, "commit", "-q", "--amend", "-m", "Amended b")
+	assertPublish(map[string]string{"a": PUBLISH_STATUS_UP_TO_DATE, "b": PUBLISH_STATUS_UPDATED})
+
+	// A teammate pushes to b, which we haven't fetched
+	cloneDir := filepath.Join(t.TempDir(), "clone")
+	gitCommand(t, "clone", "-q", "--branch", "b", remoteDir, cloneDir)
+	teammate := exec.Command("git", "-c", "user.name=Teammate", "-c", "user.email=teammate@example.com", "commit", "-q", "--allow-empty", "-m", "Teammate")
+	teammate.Dir = cloneDir
+	if output, err := teammate.CombinedOutput(); err != nil {
+		t.Fatalf("teammate commit: %v\n%s", err, output)
+	}
+	gitCommand(t, "-C", cloneDir, "push", "-q", "origin", "b")
+	teammateSha := gitCommand(t, "-C", cloneDir, "rev-parse", "HEAD")
+
+	gitCommand(t, "commit", "-q", "--amend", "-m", "Amended b again")
+	assertPublish(map[string]string{"a": PUBLISH_STATUS_UP_TO_DATE, "b": PUBLISH_STATUS_REJECTED})
+
+	if got := gitCommand(t, "--git-dir", remoteDir, "rev-parse", "b"); got != teammateSha {
+		t.Errorf("remote b = %s, want teammate's %s", got, teammateSha)
+	}
+}
+
+func TestPublishStack_FeaturesRemote(t *testing.T) {
+	repo := newTestRepository(t)
+	newTestRemote(t)
+
+	forkDir := filepath.Join(t.TempDir(), "fork.git")
+	gitCommand(t, "init", "-q", "--bare", forkDir)
+	gitCommand(t, "remote", "add", "fork", forkDir)
+
+	previousConfig := core.Config
+	t.Cleanup(func() { core.Config = previousConfig })
+	core.Config = viper.New()
+	core.Config.Set("remote.features", "fork")
+
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a")
+
+	results, err := repo.PublishStack()
+	if err != nil {
+		t.Fatalf("PublishStack() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Status != PUBLISH_STATUS_CREATED {
+		t.Fatalf("PublishStack() = %+v, want a created", results)
+	}
+	if got := gitCommand(t, "rev-parse", "--abbrev-ref", "a@{upstream}"); got != "fork/a" {
+		t.Errorf("upstream of a = %s, want fork/a", got)
+	}
+}
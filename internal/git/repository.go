@@ -2,23 +2,23 @@ package git
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"net"
-	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/fatih/color"
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
+	"go.olrik.dev/pila/internal/core"
 )
 
 type LocalRepository struct {
-	Type       string
+	Type       string // Forge type of origin, "unknown" until detected by ForgeType
 	Repository *git.Repository
+	WorkDir    string // Directory git commands run in, the current directory when empty
 }
 
 func GetLocalRepository() (*LocalRepository, error) {
@@ -32,8 +32,6 @@ func GetLocalRepository() (*LocalRepository, error) {
 	}
 	repo.Repository = r
 
-	// repo.detectType()
-
 	return repo, nil
 }
 
@@ -59,7 +57,22 @@ func (r *LocalRepository) ExecuteGitCommand(arg ...string) (string, error) {
 }
 
 func (r *LocalRepository) ExecuteGitCommandQuiet(arg ...string) (string, error) {
+	stdout, stderr, err := r.executeGitCommandCapture("", arg...)
+	if err != nil {
+		return stderr, err
+	}
+
+	return strings.TrimSpace(stdout), nil
+}
+
+// executeGitCommandCapture runs git in the work dir and returns both of its outputs, for commands that
+// report results on stdout even when exiting non-zero
+func (r *LocalRepository) executeGitCommandCapture(input string, arg ...string) (string, string, error) {
 	cmd := exec.Command("git", arg...)
+	cmd.Dir = r.WorkDir
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -68,112 +81,184 @@ func (r *LocalRepository) ExecuteGitCommandQuiet(arg ...string) (string, error)
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
+
+	return stdout.String(), stderr.String(), err
+}
+
+// RemoteURL returns the first URL configured for the named remote
+func (r *LocalRepository) RemoteURL(remoteName string) (string, error) {
+	remote, err := r.Repository.Remote(remoteName)
 	if err != nil {
-		return stderr.String(), err
+		return "", fmt.Errorf("remote '%s': %w", remoteName, err)
 	}
 
-	return strings.TrimSpace(stdout.String()), nil
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return "", fmt.Errorf("remote '%s' has no URL", remoteName)
+	}
+
+	return urls[0], nil
 }
 
-// Returns branch name of merge or empty string
-func (r *LocalRepository) OngoingMergeBranchName() (string, error) {
-	mergeHeadShaBytes, err := os.ReadFile(".git/MERGE_HEAD")
-	if err != nil {
-		return "", err
+// parseRemoteURL splits a git remote URL into scheme, host and repository path.
+// Handles both URL syntax (ssh://, https://) and scp-like syntax (git@host:group/project.git),
+// the latter is reported as ssh. The port is only kept for http(s) remotes, as an ssh port
+// says nothing about where the web interface lives.
+func parseRemoteURL(rawURL string) (scheme, host, path string, err error) {
+	if !strings.Contains(rawURL, "://") {
+		userHost, repoPath, found := strings.Cut(rawURL, ":")
+		if !found {
+			return "", "", "", fmt.Errorf("unable to parse remote URL '%s'", rawURL)
+		}
+		_, host, hasUser := strings.Cut(userHost, "@")
+		if !hasUser {
+			host = userHost
+		}
+		rawURL = fmt.Sprintf("ssh://%s/%s", host, repoPath)
 	}
-	branchName, err := r.ExecuteGitCommandQuiet("name-rev", "--name-only", strings.TrimSpace(string(mergeHeadShaBytes)))
+
+	remoteURL, err := url.Parse(rawURL)
 	if err != nil {
-		return "", err
+		return "", "", "", err
+	}
+	if remoteURL.Hostname() == "" {
+		return "", "", "", fmt.Errorf("unable to parse remote URL '%s'", rawURL)
 	}
 
-	return branchName, nil
+	host = remoteURL.Hostname()
+	if remoteURL.Scheme == "http" || remoteURL.Scheme == "https" {
+		host = remoteURL.Host
+	}
+
+	path = strings.Trim(remoteURL.Path, "/")
+	path = strings.TrimSuffix(path, ".git")
+
+	return remoteURL.Scheme, host, path, nil
 }
 
-func (r *LocalRepository) detectType() error {
-	fmt.Println("Detecting type")
+// configString returns the config value for key, or fallback when it is not set
+func configString(key, fallback string) string {
+	if core.Config == nil || !core.Config.IsSet(key) {
+		return fallback
+	}
+
+	return core.Config.GetString(key)
+}
+
+// configBool returns the config value for key, or fallback when it is not set
+func configBool(key string, fallback bool) bool {
+	if core.Config == nil || !core.Config.IsSet(key) {
+		return fallback
+	}
 
-	// Look at origin
-	cfg, err := r.Repository.ConfigScoped(config.GlobalScope)
+	return core.Config.GetBool(key)
+}
+
+// GitCommonDir returns the absolute path of the git directory shared by all worktrees
+func (r *LocalRepository) GitCommonDir() (string, error) {
+	output, err := r.ExecuteGitCommandQuiet("rev-parse", "--path-format=absolute", "--git-common-dir")
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("%s", strings.TrimSpace(output))
 	}
-	// spew.Dump(cfg)
-	fmt.Println(cfg.Init.DefaultBranch)
-	fmt.Println(cfg.Remotes["origin"].URLs[0])
 
-	gitOriginUrl, err := url.Parse(cfg.Remotes["origin"].URLs[0])
+	return output, nil
+}
+
+// GitDir returns the absolute path of the git directory of the current worktree
+func (r *LocalRepository) GitDir() (string, error) {
+	output, err := r.ExecuteGitCommandQuiet("rev-parse", "--absolute-git-dir")
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("%s", strings.TrimSpace(output))
 	}
 
-	hostname := gitOriginUrl.Hostname()
-	fmt.Println(gitOriginUrl.Hostname())
+	return output, nil
+}
 
-	if hostname == "gitlab.com" {
-		r.Type = "gitlab"
-		return nil
+// TopLevel returns the absolute path of the top level directory of the current worktree
+func (r *LocalRepository) TopLevel() (string, error) {
+	output, err := r.ExecuteGitCommandQuiet("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("%s", strings.TrimSpace(output))
 	}
 
-	if hostname == "github.com" {
-		r.Type = "github"
-		return nil
+	return output, nil
+}
+
+// PilaDir returns the directory pila keeps its state in, inside the git directory
+func (r *LocalRepository) PilaDir() (string, error) {
+	gitDir, err := r.GitDir()
+	if err != nil {
+		return "", err
 	}
 
-	var possibleGitLabHttpURLs []string
-	switch gitOriginUrl.Scheme {
-	case "ssh":
-		possibleGitLabHttpURLs = []string{
-			"https://" + hostname + "/",
-			"http://" + hostname + "/",
-		}
+	pilaDir := filepath.Join(gitDir, "pila")
+	if err := os.MkdirAll(pilaDir, 0o755); err != nil {
+		return "", err
+	}
 
-	case "http":
-		possibleGitLabHttpURLs = []string{
-			"http://" + hostname + "/",
-		}
+	return pilaDir, nil
+}
 
-	case "https":
-		possibleGitLabHttpURLs = []string{
-			"https://" + hostname + "/",
-		}
-	default:
-		panic("Unknown scheme")
-	}
-
-	client := &http.Client{
-		Timeout: 2 * time.Second,
-		Transport: &http.Transport{
-			DialContext: (&net.Dialer{
-				Timeout:   2 * time.Second, // Connect timeout
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			TLSHandshakeTimeout:   2 * time.Second,
-			ResponseHeaderTimeout: 2 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-		},
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// Return an error to stop following redirects
-			return http.ErrUseLastResponse
-		},
-	}
-
-	for _, possibleURL := range possibleGitLabHttpURLs {
-		req, err := http.NewRequest(http.MethodHead, possibleURL, nil)
-		if err != nil {
-			panic("unable to create HEAD request")
-		}
+// RebaseInProgress reports whether a rebase has stopped and is waiting for the user
+func (r *LocalRepository) RebaseInProgress() bool {
+	gitDir, err := r.GitDir()
+	if err != nil {
+		return false
+	}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			continue
-		}
-		defer resp.Body.Close()
-		fmt.Println(resp.StatusCode)
-		if resp.Header.Get("X-Gitlab-Meta") != "" {
-			r.Type = "gitlab"
-			return nil
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		if _, err := os.Stat(filepath.Join(gitDir, dir)); err == nil {
+			return true
 		}
 	}
 
-	return nil
+	return false
+}
+
+// GitPath returns the absolute path of a file in the git directory, e.g. MERGE_MSG
+func (r *LocalRepository) GitPath(name string) (string, error) {
+	output, err := r.ExecuteGitCommandQuiet("rev-parse", "--git-path", name)
+	if err != nil {
+		return "", fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(r.WorkDir, output)
+	}
+
+	return filepath.Abs(output)
+}
+
+// MergeInProgress reports whether a merge has stopped and is waiting for the user
+func (r *LocalRepository) MergeInProgress() bool {
+	_, err := r.ExecuteGitCommandQuiet("rev-parse", "-q", "--verify", "MERGE_HEAD")
+	return err == nil
+}
+
+// SquashInProgress reports whether a squash merge has stopped, or is waiting to be committed. Squash merges leave
+// no MERGE_HEAD, only SQUASH_MSG, which survives resets, so staged changes are required as well.
+func (r *LocalRepository) SquashInProgress() bool {
+	squashMessagePath, err := r.GitPath("SQUASH_MSG")
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(squashMessagePath); err != nil {
+		return false
+	}
+
+	_, err = r.ExecuteGitCommandQuiet("diff", "--cached", "--quiet")
+	return err != nil
+}
+
+// Returns branch name of merge or empty string
+func (r *LocalRepository) OngoingMergeBranchName() (string, error) {
+	mergeHeadSha, err := r.ExecuteGitCommandQuiet("rev-parse", "-q", "--verify", "MERGE_HEAD")
+	if err != nil {
+		return "", errors.New("no merge in progress")
+	}
+	branchName, err := r.ExecuteGitCommandQuiet("name-rev", "--name-only", mergeHeadSha)
+	if err != nil {
+		return "", err
+	}
+
+	return branchName, nil
 }
@@ -0,0 +1,35 @@
+package git
+
+import "testing"
+
+func TestParseRemoteURL(t *testing.T) {
+	tests := []struct {
+		rawURL string
+		scheme string
+		host   string
+		path   string
+	}{
+		{"git@gitlab.com:group/sub/project.git", "ssh", "gitlab.com", "group/sub/project"},
+		{"gitlab.example.com:group/project", "ssh", "gitlab.example.com", "group/project"},
+		{"ssh://git@github.com:22/owner/repo.git", "ssh", "github.com", "owner/repo"},
+		{"https://github.com/owner/repo.git", "https", "github.com", "owner/repo"},
+		{"http://gitea.local:3000/owner/repo", "http", "gitea.local:3000", "owner/repo"},
+	}
+
+	for _, tt := range tests {
+		scheme, host, path, err := parseRemoteURL(tt.rawURL)
+		if err != nil {
+			t.Errorf("parseRemoteURL(%q) error = %v", tt.rawURL, err)
+			continue
+		}
+		if scheme != tt.scheme || host != tt.host || path != tt.path {
+			t.Errorf("parseRemoteURL(%q) = %q, %q, %q, want %q, %q, %q", tt.rawURL, scheme, host, path, tt.scheme, tt.host, tt.path)
+		}
+	}
+}
+
+func TestParseRemoteURL_LocalPath(t *testing.T) {
+	if _, _, _, err := parseRemoteURL("/srv/git/project.git"); err == nil {
+		t.Error("parseRemoteURL() expected error for local path, got nil")
+	}
+}
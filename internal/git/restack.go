@@ -0,0 +1,323 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const RESTACK_STATE_FILENAME = "restack.yaml"
+
+// RestackConflictError is returned when rebasing a branch onto its parent stops on a conflict
+type RestackConflictError struct {
+	BranchName string
+	ParentName string
+}
+
+func (e *RestackConflictError) Error() string {
+	return fmt.Sprintf("conflict while rebasing branch '%s' onto '%s'", e.BranchName, e.ParentName)
+}
+
+// RestackState is the todo list of an ongoing restack, persisted so it can be continued or aborted
+type RestackState struct {
+	OriginalBranch string        `yaml:"original_branch"`
+	Steps          []RestackStep `yaml:"steps"`
+}
+
+type RestackStep struct {
+	Branch       string `yaml:"branch"`
+	Parent       string `yaml:"parent"`
+	Base         string `yaml:"base"`           // Parent commit the branch is based on before restacking
+	Onto         string `yaml:"onto,omitempty"` // Parent commit the branch is being rebased onto
+	OriginalSha  string `yaml:"original_sha"`
+	OriginalBase string `yaml:"original_base,omitempty"` // Recorded pila-base before restacking
+	Done         bool   `yaml:"done"`
+}
+
+func restackStatePath(r *LocalRepository) (string, error) {
+	pilaDir, err := r.PilaDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(pilaDir, RESTACK_STATE_FILENAME), nil
+}
+
+// LoadRestackState loads the state of an ongoing restack
+func (r *LocalRepository) LoadRestackState() (*RestackState, error) {
+	path, err := restackStatePath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.New("no restack in progress")
+		}
+		return nil, err
+	}
+
+	var state RestackState
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func (r *LocalRepository) saveRestackState(state *RestackState) error {
+	path, err := restackStatePath(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (r *LocalRepository) removeRestackState() error {
+	path, err := restackStatePath(r)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Restack rebases every branch in the stack of the checked out branch onto its parent, bottom up
+func (r *LocalRepository) Restack() error {
+	if _, err := r.LoadRestackState(); err == nil {
+		return errors.New("a restack is already in progress, please run 'pila branch restack continue' or 'pila branch restack abort' first")
+	}
+
+	mainBranchName, err := r.MainBranchName()
+	if err != nil {
+		return err
+	}
+
+	checkedOutBranchName, err := r.CheckedOutBranchName()
+	if err != nil {
+		return err
+	}
+
+	stack, err := r.GetBranchStack(mainBranchName, checkedOutBranchName)
+	if err != nil {
+		return err
+	}
+
+	steps, err := r.planRestack(stack)
+	if err != nil {
+		return err
+	}
+
+	return r.startRestack(&RestackState{OriginalBranch: checkedOutBranchName, Steps: steps})
+}
+
+// planRestack figures out what every branch in the stack is based on, before anything is moved
+func (r *LocalRepository) planRestack(stack *StackGraph) ([]RestackStep, error) {
+	steps := []RestackStep{}
+	var planErr error
+	stack.Walk(func(node *StackNode, depth int) {
+		if node.Parent == nil || planErr != nil {
+			return
+		}
+
+		base, err := r.stackBaseOf(node.Branch, node.Parent.Branch)
+		if err != nil {
+			planErr = err
+			return
+		}
+
+		steps = append(steps, RestackStep{
+			Branch:       node.Branch,
+			Parent:       node.Parent.Branch,
+			Base:         base,
+			OriginalSha:  node.Sha,
+			OriginalBase: r.StackBase(node.Branch),
+		})
+	})
+	if planErr != nil {
+		return nil, planErr
+	}
+
+	return steps, nil
+}
+
+// startRestack saves the todo list, so it can be continued or aborted, and starts rebasing
+func (r *LocalRepository) startRestack(state *RestackState) error {
+	if err := r.saveRestackState(state); err != nil {
+		return err
+	}
+
+	return r.restackContinue(state)
+}
+
+// RestackContinue continues a restack after the user has resolved a conflict
+func (r *LocalRepository) RestackContinue() error {
+	state, err := r.LoadRestackState()
+	if err != nil {
+		return err
+	}
+
+	if r.RebaseInProgress() {
+		r.Note("Continue rebase")
+		output, err := r.ExecuteGitCommand("-c", "core.editor=true", "rebase", "--continue")
+		if output != "" {
+			fmt.Println(output)
+		}
+		if err != nil {
+			for _, step := range state.Steps {
+				if !step.Done {
+					return &RestackConflictError{BranchName: step.Branch, ParentName: step.Parent}
+				}
+			}
+			return err
+		}
+
+		// The stopped step is now done
+		for i := range state.Steps {
+			step := &state.Steps[i]
+			if !step.Done {
+				if err := r.SetStackParent(step.Branch, step.Parent, step.Onto); err != nil {
+					return err
+				}
+				step.Done = true
+				break
+			}
+		}
+		if err := r.saveRestackState(state); err != nil {
+			return err
+		}
+	}
+
+	return r.restackContinue(state)
+}
+
+// RestackAbort stops an ongoing restack and puts every branch back where it was
+func (r *LocalRepository) RestackAbort() error {
+	state, err := r.LoadRestackState()
+	if err != nil {
+		return err
+	}
+
+	if r.RebaseInProgress() {
+		r.Note("Abort rebase")
+		output, err := r.ExecuteGitCommand("rebase", "--abort")
+		if err != nil {
+			return fmt.Errorf("%s", strings.TrimSpace(output))
+		}
+	}
+
+	// Detach, so the checked out branch can be moved too
+	if _, err := r.ExecuteGitCommandQuiet("checkout", "-q", "--detach"); err != nil {
+		return err
+	}
+
+	// Branches that stopped on a conflict may have been rebased by hand, so every branch that moved is reset
+	for _, step := range state.Steps {
+		if sha, _ := r.GetSha("refs/heads/" + step.Branch); !step.Done && sha == step.OriginalSha {
+			continue
+		}
+
+		r.Note("Reset %s to %s", step.Branch, step.OriginalSha)
+		output, err := r.ExecuteGitCommand("update-ref", "refs/heads/"+step.Branch, step.OriginalSha)
+		if err != nil {
+			return fmt.Errorf("%s", strings.TrimSpace(output))
+		}
+
+		if step.OriginalBase == "" {
+			r.UnsetStackParent(step.Branch)
+		} else if err := r.SetStackParent(step.Branch, step.Parent, step.OriginalBase); err != nil {
+			return err
+		}
+	}
+
+	r.Note("Checkout %s", state.OriginalBranch)
+	if output, err := r.ExecuteGitCommand("checkout", state.OriginalBranch); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	return r.removeRestackState()
+}
+
+// restackContinue rebases the remaining branches of the todo list
+func (r *LocalRepository) restackContinue(state *RestackState) error {
+	for i := range state.Steps {
+		step := &state.Steps[i]
+		if step.Done {
+			continue
+		}
+
+		onto, err := r.GetSha(step.Parent)
+		if err != nil {
+			return err
+		}
+		step.Onto = onto
+
+		// Already on top of the parent, either because it didn't move or because the user
+		// finished the rebase with git themselves
+		if _, err := r.ExecuteGitCommandQuiet("merge-base", "--is-ancestor", onto, step.Branch); err == nil {
+			r.Note("%s is up to date with %s", step.Branch, step.Parent)
+		} else {
+			r.Note("Rebase %s onto %s", step.Branch, step.Parent)
+			if err := r.saveRestackState(state); err != nil {
+				return err
+			}
+
+			output, err := r.ExecuteGitCommand("rebase", "--onto", onto, step.Base, step.Branch)
+			if output != "" {
+				fmt.Println(output)
+			}
+			if err != nil {
+				if r.RebaseInProgress() {
+					return &RestackConflictError{BranchName: step.Branch, ParentName: step.Parent}
+				}
+				return err
+			}
+		}
+
+		if err := r.SetStackParent(step.Branch, step.Parent, onto); err != nil {
+			return err
+		}
+		step.Done = true
+		if err := r.saveRestackState(state); err != nil {
+			return err
+		}
+	}
+
+	r.Note("Checkout %s", state.OriginalBranch)
+	if output, err := r.ExecuteGitCommand("checkout", state.OriginalBranch); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	return r.removeRestackState()
+}
+
+// stackBaseOf returns the parent commit the branch is currently based on: the recorded base when it is
+// still part of the branch, otherwise the point where the branch forked off of the parent
+func (r *LocalRepository) stackBaseOf(branchName, parentName string) (string, error) {
+	if base := r.StackBase(branchName); base != "" && r.StackParent(branchName) == parentName {
+		if _, err := r.ExecuteGitCommandQuiet("merge-base", "--is-ancestor", base, branchName); err == nil {
+			return base, nil
+		}
+	}
+
+	base, err := r.ExecuteGitCommandQuiet("merge-base", "--fork-point", parentName, branchName)
+	if err == nil && base != "" {
+		return base, nil
+	}
+
+	base, err = r.ExecuteGitCommandQuiet("merge-base", parentName, branchName)
+	if err != nil {
+		return "", fmt.Errorf("%s and %s have no common history", branchName, parentName)
+	}
+
+	return base, nil
+}
@@ -0,0 +1,127 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRestack_RebasesChildrenOntoAmendedParent(t *testing.T) {
+	repo := newTestRepository(t)
+	gitCommand(t, "remote", "add", "origin", ".")
+	gitCommand(t, "update-ref", "refs/remotes/origin/main", "main")
+	gitCommand(t, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/main")
+
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a")
+	if err := repo.CreateStackBranch("b"); err != nil {
+		t.Fatalf("CreateStackBranch() error = %v", err)
+	}
+	commitFile(t, "b.txt", "b")
+	if err := repo.CreateStackBranch("c"); err != nil {
+		t.Fatalf("CreateStackBranch() error = %v", err)
+	}
+	commitFile(t, "c.txt", "c")
+
+	gitCommand(t, "checkout", "-q", "a")
+	commitFile(t, "a.txt", "a amended")
+	gitCommand(t, "commit", "-q", "--amend", "-m", "a amended")
+
+	if err := repo.Restack(); err != nil {
+		t.Fatalf("Restack() error = %v", err)
+	}
+
+	for _, pair := range [][2]string{{"a", "b"}, {"b", "c"}} {
+		if _, err := repo.ExecuteGitCommandQuiet("merge-base", "--is-ancestor", pair[0], pair[1]); err != nil {
+			t.Errorf("%s is not on top of %s after restack", pair[1], pair[0])
+		}
+	}
+	if got := gitCommand(t, "rev-list", "--count", "a..c"); got != "2" {
+		t.Errorf("commits between a and c = %s, want 2", got)
+	}
+	if got := gitCommand(t, "rev-parse", "--abbrev-ref", "HEAD"); got != "a" {
+		t.Errorf("checked out branch = %s, want a", got)
+	}
+	if _, err := repo.LoadRestackState(); err == nil {
+		t.Error("restack state still exists after a clean restack")
+	}
+}
+
+func TestRestack_ConflictThenAbort(t *testing.T) {
+	repo := newTestRepository(t)
+	gitCommand(t, "remote", "add", "origin", ".")
+	gitCommand(t, "update-ref", "refs/remotes/origin/main", "main")
+	gitCommand(t, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/main")
+
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a")
+	if err := repo.CreateStackBranch("b"); err != nil {
+		t.Fatalf("CreateStackBranch() error = %v", err)
+	}
+	commitFile(t, "shared.txt", "from b")
+	bSha := gitCommand(t, "rev-parse", "b")
+
+	gitCommand(t, "checkout", "-q", "a")
+	commitFile(t, "shared.txt", "from a")
+	aSha := gitCommand(t, "rev-parse", "a")
+
+	err := repo.Restack()
+	var conflictErr *RestackConflictError
+	if !errors.As(err, &conflictErr) || conflictErr.BranchName != "b" {
+		t.Fatalf("Restack() error = %v, want conflict on b", err)
+	}
+
+	if err := repo.RestackAbort(); err != nil {
+		t.Fatalf("RestackAbort() error = %v", err)
+	}
+	if got := gitCommand(t, "rev-parse", "b"); got != bSha {
+		t.Errorf("b = %s after abort, want %s", got, bSha)
+	}
+	if got := gitCommand(t, "rev-parse", "a"); got != aSha {
+		t.Errorf("a = %s after abort, want %s", got, aSha)
+	}
+	if repo.RebaseInProgress() {
+		t.Error("rebase still in progress after abort")
+	}
+	if _, err := os.Stat("shared.txt"); err != nil {
+		t.Errorf("working tree not restored: %v", err)
+	}
+}
+
+func TestRestack_ConflictResolvedByHandThenAbort(t *testing.T) {
+	repo := newTestRepository(t)
+	gitCommand(t, "remote", "add", "origin", ".")
+	gitCommand(t, "update-ref", "refs/remotes/origin/main", "main")
+	gitCommand(t, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/main")
+
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a")
+	if err := repo.CreateStackBranch("b"); err != nil {
+		t.Fatalf("CreateStackBranch() error = %v", err)
+	}
+	commitFile(t, "shared.txt", "from b")
+	bSha := gitCommand(t, "rev-parse", "b")
+
+	gitCommand(t, "checkout", "-q", "a")
+	commitFile(t, "shared.txt", "from a")
+
+	err := repo.Restack()
+	var conflictErr *RestackConflictError
+	if !errors.As(err, &conflictErr) || conflictErr.BranchName != "b" {
+		t.Fatalf("Restack() error = %v, want conflict on b", err)
+	}
+
+	// Finish the rebase of b without pila, then change our mind
+	commitFile(t, "shared.txt", "resolved")
+	gitCommand(t, "-c", "core.editor=true", "rebase", "--continue")
+	if got := gitCommand(t, "rev-parse", "b"); got == bSha {
+		t.Fatal("b was not rebased by hand")
+	}
+
+	if err := repo.RestackAbort(); err != nil {
+		t.Fatalf("RestackAbort() error = %v", err)
+	}
+	if got := gitCommand(t, "rev-parse", "b"); got != bSha {
+		t.Errorf("b = %s after abort, want %s", got, bSha)
+	}
+}
@@ -0,0 +1,258 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// StackNode is a branch in a stack, with the branches branching off of it as children
+type StackNode struct {
+	Branch   string
+	Sha      string
+	Commits  int // Number of first parent commits between the parent branch and this branch
+	Parent   *StackNode
+	Children []*StackNode
+}
+
+// StackGraph is a tree of local branches rooted at the main branch
+type StackGraph struct {
+	Root  *StackNode
+	Nodes map[string]*StackNode
+}
+
+// BuildStackGraph places every local branch in a tree rooted at the main branch.
+//
+// Parents recorded by `pila branch create/track` are used when present. Otherwise the parent
+// of a branch is the first branch found when walking its first parent history, or main once
+// the walk reaches a commit that is part of main. Following only first parents means merging
+// main (or another branch) into a stack branch does not move it in the tree.
+func (r *LocalRepository) BuildStackGraph(mainBranchName string) (*StackGraph, error) {
+	heads, err := r.Heads()
+	if err != nil {
+		return nil, err
+	}
+
+	mainSha, ok := heads[mainBranchName]
+	if !ok {
+		// No local main branch, use the remote one
+		mainSha, err = r.GetSha(fmt.Sprintf("%s/%s", r.BaseRemote(), mainBranchName))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mainCommit, err := r.Repository.CommitObject(plumbing.NewHash(mainSha))
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &StackGraph{
+		Root:  &StackNode{Branch: mainBranchName, Sha: mainSha},
+		Nodes: map[string]*StackNode{},
+	}
+	graph.Nodes[mainBranchName] = graph.Root
+
+	// Create lookup map for known local branches
+	shaToBranches := make(map[string][]string)
+	branchNames := []string{}
+	for branchName, sha := range heads {
+		if branchName == mainBranchName {
+			continue
+		}
+		shaToBranches[sha] = append(shaToBranches[sha], branchName)
+		branchNames = append(branchNames, branchName)
+		graph.Nodes[branchName] = &StackNode{Branch: branchName, Sha: sha}
+	}
+	for sha := range shaToBranches {
+		sort.Strings(shaToBranches[sha])
+	}
+	sort.Strings(branchNames)
+
+	mainAncestry := newAncestrySet(mainCommit)
+	for _, branchName := range branchNames {
+		node := graph.Nodes[branchName]
+
+		parentName := mainBranchName
+		node.Commits, err = r.countFirstParentCommits(node.Sha, func(commit *object.Commit) bool {
+			if mainAncestry.contains(commit) {
+				return true
+			}
+			// Other branches pointing at the tip are siblings, not parents
+			if names := shaToBranches[commit.Hash.String()]; len(names) > 0 && commit.Hash.String() != node.Sha {
+				parentName = names[0]
+				return true
+			}
+			return false
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		node.Parent = graph.Nodes[parentName]
+	}
+
+	// Recorded parents take precedence over ancestry, unless the parent is gone or it would create a loop
+	recordedParents, err := r.StackParents()
+	if err != nil {
+		return nil, err
+	}
+	for _, branchName := range branchNames {
+		node := graph.Nodes[branchName]
+		parent, ok := graph.Nodes[recordedParents[branchName]]
+		if !ok || parent.isAbove(node) {
+			continue
+		}
+
+		// Count commits down to where the branch was based on the parent, which differs from
+		// the parent's tip when the parent has been amended or rebased since
+		baseSha := r.StackBase(branchName)
+		node.Commits, err = r.countFirstParentCommits(node.Sha, func(commit *object.Commit) bool {
+			sha := commit.Hash.String()
+			return sha == parent.Sha || sha == baseSha || mainAncestry.contains(commit)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		node.Parent = parent
+	}
+
+	for _, branchName := range branchNames {
+		node := graph.Nodes[branchName]
+		node.Parent.Children = append(node.Parent.Children, node)
+	}
+
+	return graph, nil
+}
+
+// countFirstParentCommits counts the commits from sha along first parents until stop returns true,
+// or the start of history is reached
+func (r *LocalRepository) countFirstParentCommits(sha string, stop func(commit *object.Commit) bool) (int, error) {
+	commit, err := r.Repository.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return 0, err
+	}
+
+	commits := 0
+	for !stop(commit) {
+		commits++
+		commit, err = commit.Parent(0)
+		if errors.Is(err, object.ErrParentNotFound) {
+			break // Unrelated history
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return commits, nil
+}
+
+// isAbove reports whether the node is stacked somewhere on top of other
+func (n *StackNode) isAbove(other *StackNode) bool {
+	for node := n; node != nil; node = node.Parent {
+		if node == other {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBranchStack returns the stack the branch is part of: the branches below it down to main,
+// and every branch above it. The stack of main itself is every stack.
+func (r *LocalRepository) GetBranchStack(mainBranchName, branchName string) (*StackGraph, error) {
+	graph, err := r.BuildStackGraph(mainBranchName)
+	if err != nil {
+		return nil, err
+	}
+
+	return graph.Stack(branchName)
+}
+
+// Stack returns a copy of the graph only containing the branches below and above the branch
+func (g *StackGraph) Stack(branchName string) (*StackGraph, error) {
+	node, ok := g.Nodes[branchName]
+	if !ok {
+		return nil, fmt.Errorf("branch '%s' is not part of any stack", branchName)
+	}
+
+	stack := &StackGraph{Nodes: map[string]*StackNode{}}
+
+	// Copy the branch and everything above it
+	var copySubtree func(node, parent *StackNode) *StackNode
+	copySubtree = func(node, parent *StackNode) *StackNode {
+		copied := &StackNode{Branch: node.Branch, Sha: node.Sha, Commits: node.Commits, Parent: parent}
+		stack.Nodes[copied.Branch] = copied
+		for _, child := range node.Children {
+			copied.Children = append(copied.Children, copySubtree(child, copied))
+		}
+		return copied
+	}
+	child := copySubtree(node, nil)
+
+	// Copy the line of branches below it
+	for parent := node.Parent; parent != nil; parent = parent.Parent {
+		copied := &StackNode{Branch: parent.Branch, Sha: parent.Sha, Commits: parent.Commits, Children: []*StackNode{child}}
+		stack.Nodes[copied.Branch] = copied
+		child.Parent = copied
+		child = copied
+	}
+	stack.Root = child
+
+	return stack, nil
+}
+
+// Walk visits every node depth first, parents before children
+func (g *StackGraph) Walk(visit func(node *StackNode, depth int)) {
+	var walk func(node *StackNode, depth int)
+	walk = func(node *StackNode, depth int) {
+		visit(node, depth)
+		for _, child := range node.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(g.Root, 0)
+}
+
+// Branches returns all branch names in the graph, parents before children
+func (g *StackGraph) Branches() []string {
+	branchNames := []string{}
+	g.Walk(func(node *StackNode, depth int) {
+		branchNames = append(branchNames, node.Branch)
+	})
+	return branchNames
+}
+
+// ancestrySet answers whether commits are reachable from a given commit, walking its
+// history only as far as needed. Committer dates can't tell when to stop, as they are not
+// ordered along history after rebases or with clock skew, so commits that are not reachable
+// walk the whole history, once.
+type ancestrySet struct {
+	iter object.CommitIter
+	seen map[plumbing.Hash]bool
+	done bool
+}
+
+func newAncestrySet(commit *object.Commit) *ancestrySet {
+	return &ancestrySet{
+		iter: object.NewCommitIterCTime(commit, nil, nil),
+		seen: map[plumbing.Hash]bool{},
+	}
+}
+
+func (s *ancestrySet) contains(commit *object.Commit) bool {
+	for !s.done && !s.seen[commit.Hash] {
+		next, err := s.iter.Next()
+		if err != nil {
+			s.done = true
+			break
+		}
+		s.seen[next.Hash] = true
+	}
+
+	return s.seen[commit.Hash]
+}
@@ -0,0 +1,144 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stack parents are recorded in the branch section of the git config, next to the upstream settings:
+//
+//	[branch "feature-ui"]
+//		pila-parent = feature-api
+//		pila-base = 1a2b3c...
+//
+// pila-base is the sha of the parent the branch was last based on, which is what the branch has
+// to be rebased away from when the parent moves. Git drops the section when the branch is deleted.
+const (
+	STACK_PARENT_CONFIG_KEY = "pila-parent"
+	STACK_BASE_CONFIG_KEY   = "pila-base"
+)
+
+func stackConfigKey(branchName, key string) string {
+	return fmt.Sprintf("branch.%s.%s", branchName, key)
+}
+
+// StackParents returns the recorded parent of every branch that has one
+func (r *LocalRepository) StackParents() (map[string]string, error) {
+	parents := map[string]string{}
+
+	output, err := r.ExecuteGitCommandQuiet("config", "--get-regexp", fmt.Sprintf(`^branch\..*\.%s$`, STACK_PARENT_CONFIG_KEY))
+	if err != nil {
+		// Exit code 1 means no matches
+		return parents, nil
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		key, parentName, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+		branchName := strings.TrimSuffix(strings.TrimPrefix(key, "branch."), "."+STACK_PARENT_CONFIG_KEY)
+		parents[branchName] = parentName
+	}
+
+	return parents, nil
+}
+
+// StackParent returns the recorded parent of the branch, or an empty string
+func (r *LocalRepository) StackParent(branchName string) string {
+	parentName, err := r.ExecuteGitCommandQuiet("config", "--get", stackConfigKey(branchName, STACK_PARENT_CONFIG_KEY))
+	if err != nil {
+		return ""
+	}
+	return parentName
+}
+
+// StackBase returns the recorded sha of the parent the branch is based on, or an empty string
+func (r *LocalRepository) StackBase(branchName string) string {
+	baseSha, err := r.ExecuteGitCommandQuiet("config", "--get", stackConfigKey(branchName, STACK_BASE_CONFIG_KEY))
+	if err != nil {
+		return ""
+	}
+	return baseSha
+}
+
+// SetStackParent records the parent of the branch, and the parent sha it is based on
+func (r *LocalRepository) SetStackParent(branchName, parentName, baseSha string) error {
+	if output, err := r.ExecuteGitCommandQuiet("config", stackConfigKey(branchName, STACK_PARENT_CONFIG_KEY), parentName); err != nil {
+		return fmt.Errorf("recording parent of %s: %s", branchName, output)
+	}
+	if output, err := r.ExecuteGitCommandQuiet("config", stackConfigKey(branchName, STACK_BASE_CONFIG_KEY), baseSha); err != nil {
+		return fmt.Errorf("recording base of %s: %s", branchName, output)
+	}
+
+	return nil
+}
+
+// UnsetStackParent forgets the recorded parent of the branch
+func (r *LocalRepository) UnsetStackParent(branchName string) error {
+	for _, key := range []string{STACK_PARENT_CONFIG_KEY, STACK_BASE_CONFIG_KEY} {
+		// Exit code 5 means the key wasn't set, which is fine
+		r.ExecuteGitCommandQuiet("config", "--unset", stackConfigKey(branchName, key))
+	}
+
+	return nil
+}
+
+// CreateStackBranch creates a new branch off of the checked out branch, checks it out
+// and records the checked out branch as its parent
+func (r *LocalRepository) CreateStackBranch(branchName string) error {
+	parentName, err := r.CheckedOutBranchName()
+	if err != nil {
+		return err
+	}
+	if parentName == "HEAD" {
+		return fmt.Errorf("unable to create a stacked branch from a detached HEAD")
+	}
+
+	parentSha, err := r.GetSha(parentName)
+	if err != nil {
+		return err
+	}
+
+	output, err := r.ExecuteGitCommand("checkout", "-b", branchName)
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	return r.SetStackParent(branchName, parentName, parentSha)
+}
+
+// TrackStackBranch adopts an existing branch into a stack, by recording its parent.
+// The branch is considered based on the point where it forked off of the parent.
+func (r *LocalRepository) TrackStackBranch(branchName, parentName string) error {
+	if branchName == parentName {
+		return fmt.Errorf("a branch can't be its own parent")
+	}
+
+	if _, err := r.GetSha(branchName); err != nil {
+		return err
+	}
+	if _, err := r.GetSha(parentName); err != nil {
+		return err
+	}
+
+	// Refuse to create loops, e.g. tracking a branch on top of one of its own children
+	seen := map[string]bool{}
+	for ancestor := parentName; ancestor != "" && !seen[ancestor]; ancestor = r.StackParent(ancestor) {
+		if ancestor == branchName {
+			return fmt.Errorf("%s is stacked on top of %s, so it can't be its parent", parentName, branchName)
+		}
+		seen[ancestor] = true
+	}
+
+	// The fork point uses the parent's reflog, so it finds the old parent tip if the parent was amended
+	baseSha, err := r.ExecuteGitCommandQuiet("merge-base", "--fork-point", parentName, branchName)
+	if err != nil || baseSha == "" {
+		baseSha, err = r.ExecuteGitCommandQuiet("merge-base", parentName, branchName)
+		if err != nil {
+			return fmt.Errorf("%s and %s have no common history", branchName, parentName)
+		}
+	}
+
+	return r.SetStackParent(branchName, parentName, baseSha)
+}
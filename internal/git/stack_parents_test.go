@@ -0,0 +1,77 @@
+package git
+
+import "testing"
+
+func TestCreateStackBranch_RecordsParent(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a")
+	aSha := gitCommand(t, "rev-parse", "a")
+
+	if err := repo.CreateStackBranch("b"); err != nil {
+		t.Fatalf("CreateStackBranch() error = %v", err)
+	}
+
+	if got := gitCommand(t, "rev-parse", "--abbrev-ref", "HEAD"); got != "b" {
+		t.Errorf("checked out branch = %s, want b", got)
+	}
+	if got := repo.StackParent("b"); got != "a" {
+		t.Errorf("StackParent(b) = %q, want %q", got, "a")
+	}
+	if got := repo.StackBase("b"); got != aSha {
+		t.Errorf("StackBase(b) = %q, want %q", got, aSha)
+	}
+}
+
+func TestBuildStackGraph_RecordedParentWins(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// Both a and b point at the same commit, so ancestry alone makes them siblings
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "branch", "b")
+
+	if err := repo.TrackStackBranch("b", "a"); err != nil {
+		t.Fatalf("TrackStackBranch() error = %v", err)
+	}
+
+	graph, err := repo.BuildStackGraph("main")
+	if err != nil {
+		t.Fatalf("BuildStackGraph() error = %v", err)
+	}
+	if got := graph.Nodes["b"].Parent.Branch; got != "a" {
+		t.Errorf("parent of b = %s, want a", got)
+	}
+	if got := graph.Nodes["b"].Commits; got != 0 {
+		t.Errorf("commits on b = %d, want 0", got)
+	}
+
+	// Parents that no longer exist fall back to ancestry
+	gitCommand(t, "checkout", "-q", "b")
+	gitCommand(t, "branch", "-q", "-D", "a")
+	graph, err = repo.BuildStackGraph("main")
+	if err != nil {
+		t.Fatalf("BuildStackGraph() error = %v", err)
+	}
+	if got := graph.Nodes["b"].Parent.Branch; got != "main" {
+		t.Errorf("parent of b after deleting a = %s, want main", got)
+	}
+}
+
+func TestTrackStackBranch_RefusesLoops(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a")
+	if err := repo.CreateStackBranch("b"); err != nil {
+		t.Fatalf("CreateStackBranch() error = %v", err)
+	}
+
+	if err := repo.TrackStackBranch("a", "b"); err == nil {
+		t.Error("TrackStackBranch() expected error when stacking a branch on its own child, got nil")
+	}
+	if err := repo.TrackStackBranch("a", "a"); err == nil {
+		t.Error("TrackStackBranch() expected error when stacking a branch on itself, got nil")
+	}
+}
@@ -0,0 +1,107 @@
+package git
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBuildStackGraph_BranchingStack(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// main <- a (2 commits) <- b <- c
+	//                       <- d (merges main)
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a1")
+	commitFile(t, "a.txt", "a2")
+	gitCommand(t, "checkout", "-q", "-b", "b")
+	commitFile(t, "b.txt", "b")
+	gitCommand(t, "checkout", "-q", "-b", "c")
+	commitFile(t, "c.txt", "c")
+	gitCommand(t, "checkout", "-q", "main")
+	commitFile(t, "main.txt", "main moved")
+	gitCommand(t, "checkout", "-q", "-b", "d", "a")
+	commitFile(t, "d.txt", "d")
+	gitCommand(t, "merge", "-q", "--no-edit", "main")
+
+	graph, err := repo.BuildStackGraph("main")
+	if err != nil {
+		t.Fatalf("BuildStackGraph() error = %v", err)
+	}
+
+	parents := map[string]string{"a": "main", "b": "a", "c": "b", "d": "a"}
+	for branchName, want := range parents {
+		node := graph.Nodes[branchName]
+		if node == nil || node.Parent == nil {
+			t.Fatalf("branch %s missing from graph", branchName)
+		}
+		if node.Parent.Branch != want {
+			t.Errorf("parent of %s = %s, want %s", branchName, node.Parent.Branch, want)
+		}
+	}
+
+	commits := map[string]int{"a": 2, "b": 1, "c": 1, "d": 2}
+	for branchName, want := range commits {
+		if got := graph.Nodes[branchName].Commits; got != want {
+			t.Errorf("commits on %s = %d, want %d", branchName, got, want)
+		}
+	}
+
+	if got, want := graph.Branches(), []string{"main", "a", "b", "c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("Branches() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildStackGraph_SkewedCommitterDates(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// The tip of main was committed by a clock running behind, so it looks older than its parent
+	t.Setenv("GIT_COMMITTER_DATE", "2024-06-01T12:00:00Z")
+	commitFile(t, "main.txt", "main")
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	t.Setenv("GIT_COMMITTER_DATE", "2024-07-01T12:00:00Z")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "checkout", "-q", "main")
+	t.Setenv("GIT_COMMITTER_DATE", "2021-01-01T12:00:00Z")
+	commitFile(t, "main.txt", "main moved")
+
+	graph, err := repo.BuildStackGraph("main")
+	if err != nil {
+		t.Fatalf("BuildStackGraph() error = %v", err)
+	}
+	if node := graph.Nodes["a"]; node.Parent.Branch != "main" || node.Commits != 1 {
+		t.Errorf("a has parent %s and %d commits, want main and 1 commit", node.Parent.Branch, node.Commits)
+	}
+}
+
+func TestStackGraph_Stack(t *testing.T) {
+	repo := newTestRepository(t)
+
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a")
+	gitCommand(t, "checkout", "-q", "-b", "b")
+	commitFile(t, "b.txt", "b")
+	gitCommand(t, "checkout", "-q", "-b", "c", "a")
+	commitFile(t, "c.txt", "c")
+	gitCommand(t, "checkout", "-q", "-b", "other", "main")
+	commitFile(t, "other.txt", "other")
+
+	stack, err := repo.GetBranchStack("main", "a")
+	if err != nil {
+		t.Fatalf("GetBranchStack() error = %v", err)
+	}
+	if got, want := stack.Branches(), []string{"main", "a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("stack of a = %v, want %v", got, want)
+	}
+
+	stack, err = repo.GetBranchStack("main", "b")
+	if err != nil {
+		t.Fatalf("GetBranchStack() error = %v", err)
+	}
+	if got, want := stack.Branches(), []string{"main", "a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("stack of b = %v, want %v", got, want)
+	}
+
+	if _, err := repo.GetBranchStack("main", "missing"); err == nil {
+		t.Error("GetBranchStack() expected error for unknown branch, got nil")
+	}
+}
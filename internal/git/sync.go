@@ -0,0 +1,270 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SyncStacks fetches the base remote and finds the stack branches whose changes already landed in the main branch,
+// either merged as is or squashed. confirmDelete decides which of those are deleted locally. The branches
+// stacked on top of merged branches are re-parented and rebased onto the main branch.
+func (r *LocalRepository) SyncStacks(confirmDelete func(branchName string) bool) error {
+	if _, err := r.LoadRestackState(); err == nil {
+		return errors.New("a restack is in progress, please run 'pila branch restack continue' or 'pila branch restack abort' first")
+	}
+
+	mainBranchName, err := r.MainBranchName()
+	if err != nil {
+		return err
+	}
+	baseRemote := r.BaseRemote()
+	remoteMainBranchName := fmt.Sprintf("%s/%s", baseRemote, mainBranchName)
+
+	r.Note("Fetch %s", baseRemote)
+	if output, err := r.ExecuteGitCommand("fetch", "--prune", baseRemote); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	checkedOutBranchName, err := r.CheckedOutBranchName()
+	if err != nil {
+		return err
+	}
+
+	graph, err := r.BuildStackGraph(mainBranchName)
+	if err != nil {
+		return err
+	}
+
+	// Parents are visited first, so a branch is only checked once its parent has been
+	merged := map[string]bool{}
+	mergedBranchNames := []string{}
+	var walkErr error
+	graph.Walk(func(node *StackNode, depth int) {
+		if node.Parent == nil || walkErr != nil {
+			return
+		}
+
+		landed, err := r.landedIn(node, remoteMainBranchName)
+		if err != nil {
+			walkErr = err
+			return
+		}
+		if landed {
+			r.Note("%s has been merged into %s", node.Branch, remoteMainBranchName)
+			merged[node.Branch] = true
+			mergedBranchNames = append(mergedBranchNames, node.Branch)
+		}
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+
+	if err := r.updateMainBranch(mainBranchName, remoteMainBranchName, checkedOutBranchName); err != nil {
+		return err
+	}
+
+	if len(mergedBranchNames) == 0 {
+		r.Note("No stack branches have been merged")
+		return nil
+	}
+
+	// Move the children of merged branches onto the closest unmerged branch below, keeping the base
+	// they have on the merged branch, so restacking only replays their own commits
+	reparented := []string{}
+	for _, branchName := range mergedBranchNames {
+		node := graph.Nodes[branchName]
+
+		newParent := node.Parent
+		for merged[newParent.Branch] {
+			newParent = newParent.Parent
+		}
+
+		for _, child := range node.Children {
+			if merged[child.Branch] {
+				continue
+			}
+
+			base, err := r.stackBaseOf(child.Branch, branchName)
+			if err != nil {
+				return err
+			}
+
+			r.Note("Move %s from %s onto %s", child.Branch, branchName, newParent.Branch)
+			if err := r.SetStackParent(child.Branch, newParent.Branch, base); err != nil {
+				return err
+			}
+			reparented = append(reparented, child.Branch)
+		}
+	}
+
+	deleteBranchNames := []string{}
+	for _, branchName := range mergedBranchNames {
+		if confirmDelete(branchName) {
+			deleteBranchNames = append(deleteBranchNames, branchName)
+		}
+	}
+
+	// Step off of the checked out branch if it is about to be deleted
+	originalBranchName := checkedOutBranchName
+	if slices.Contains(deleteBranchNames, checkedOutBranchName) {
+		originalBranchName = mainBranchName
+		r.Note("Checkout %s", mainBranchName)
+		if err := r.CheckoutBranch(mainBranchName); err != nil {
+			return err
+		}
+	}
+
+	for _, branchName := range deleteBranchNames {
+		r.Note("Delete merged branch %s", branchName)
+		if output, err := r.ExecuteGitCommand("branch", "-D", branchName); err != nil {
+			return fmt.Errorf("%s", strings.TrimSpace(output))
+		}
+	}
+
+	if len(reparented) == 0 {
+		return nil
+	}
+
+	// Rebase the stacks of the moved branches, with the usual restack continue and abort on conflicts
+	graph, err = r.BuildStackGraph(mainBranchName)
+	if err != nil {
+		return err
+	}
+
+	state := &RestackState{OriginalBranch: originalBranchName}
+	planned := map[string]bool{}
+	for _, branchName := range reparented {
+		stack, err := graph.Stack(branchName)
+		if err != nil {
+			return err
+		}
+
+		steps, err := r.planRestack(stack)
+		if err != nil {
+			return err
+		}
+		for _, step := range steps {
+			if !planned[step.Branch] {
+				planned[step.Branch] = true
+				state.Steps = append(state.Steps, step)
+			}
+		}
+	}
+
+	return r.startRestack(state)
+}
+
+// landedIn reports whether the changes of a stack branch are part of the remote main branch. That is the case
+// when the branch is an ancestor of it, when all its commits were rebased onto it, or when it was squashed into
+// a single commit on it. Branches without changes of their own never count as merged.
+func (r *LocalRepository) landedIn(node *StackNode, remoteMainBranchName string) (bool, error) {
+	base, err := r.stackBaseOf(node.Branch, node.Parent.Branch)
+	if err != nil {
+		return false, err
+	}
+	if base == node.Sha {
+		return false, nil
+	}
+
+	if _, err := r.ExecuteGitCommandQuiet("merge-base", "--is-ancestor", node.Branch, remoteMainBranchName); err == nil {
+		return true, nil
+	}
+
+	// Rebased, every commit is marked with - when an equivalent commit exists upstream
+	cherry, err := r.ExecuteGitCommandQuiet("cherry", remoteMainBranchName, node.Branch, base)
+	if err != nil {
+		return false, fmt.Errorf("%s", strings.TrimSpace(cherry))
+	}
+	if cherry != "" && !strings.Contains("\n"+cherry, "\n+") {
+		return true, nil
+	}
+
+	// Squashed, the combined diff of the branch has the same patch id as a commit upstream
+	branchPatchID, err := r.patchID(base, node.Branch)
+	if err != nil || branchPatchID == "" {
+		return false, err
+	}
+
+	mergeBase, err := r.ExecuteGitCommandQuiet("merge-base", node.Branch, remoteMainBranchName)
+	if err != nil {
+		return false, nil // Unrelated history
+	}
+
+	upstreamLog, err := r.ExecuteGitCommandQuiet("log", "-p", "--no-merges", fmt.Sprintf("%s..%s", mergeBase, remoteMainBranchName))
+	if err != nil {
+		return false, fmt.Errorf("%s", strings.TrimSpace(upstreamLog))
+	}
+	upstreamPatchIDs, err := r.stablePatchIDs(upstreamLog)
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(upstreamPatchIDs, "\n") {
+		if patchID, _, _ := strings.Cut(line, " "); patchID == branchPatchID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// patchID returns the stable patch id of the diff between two commits
+func (r *LocalRepository) patchID(from, to string) (string, error) {
+	diff, err := r.ExecuteGitCommandQuiet("diff", from, to)
+	if err != nil {
+		return "", fmt.Errorf("%s", strings.TrimSpace(diff))
+	}
+	if diff == "" {
+		return "", nil
+	}
+
+	output, err := r.stablePatchIDs(diff)
+	if err != nil {
+		return "", err
+	}
+
+	patchID, _, _ := strings.Cut(output, " ")
+	return patchID, nil
+}
+
+// stablePatchIDs feeds patches to git patch-id, which prints a "<patch id> <commit>" line per patch
+func (r *LocalRepository) stablePatchIDs(patches string) (string, error) {
+	stdout, stderr, err := r.executeGitCommandCapture(patches+"\n", "patch-id", "--stable")
+	if err != nil {
+		return "", fmt.Errorf("%s", strings.TrimSpace(stderr))
+	}
+
+	return strings.TrimSpace(stdout), nil
+}
+
+// updateMainBranch fast-forwards the local main branch to the remote one, creating it when missing
+func (r *LocalRepository) updateMainBranch(mainBranchName, remoteMainBranchName, checkedOutBranchName string) error {
+	if _, err := r.GetSha("refs/heads/" + mainBranchName); err != nil {
+		r.Note("Create %s from %s", mainBranchName, remoteMainBranchName)
+		if output, err := r.ExecuteGitCommand("branch", "--track", mainBranchName, remoteMainBranchName); err != nil {
+			return fmt.Errorf("%s", strings.TrimSpace(output))
+		}
+		return nil
+	}
+
+	if _, err := r.ExecuteGitCommandQuiet("merge-base", "--is-ancestor", mainBranchName, remoteMainBranchName); err != nil {
+		r.Warn("%s has commits that are not on %s, leaving it as is", mainBranchName, remoteMainBranchName)
+		return nil
+	}
+
+	r.Note("Fast-forward %s to %s", mainBranchName, remoteMainBranchName)
+	var output string
+	var err error
+	if checkedOutBranchName == mainBranchName {
+		output, err = r.ExecuteGitCommand("merge", "--ff-only", remoteMainBranchName)
+	} else {
+		output, err = r.ExecuteGitCommand("branch", "-f", mainBranchName, remoteMainBranchName)
+	}
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+
+	return nil
+}
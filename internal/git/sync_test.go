@@ -0,0 +1,75 @@
+package git
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncStacks_SquashMergedBottomBranch(t *testing.T) {
+	repo := newTestRepository(t)
+
+	remoteDir := filepath.Join(t.TempDir(), "remote.git")
+	gitCommand(t, "init", "-q", "--bare", remoteDir)
+	gitCommand(t, "remote", "add", "origin", remoteDir)
+	gitCommand(t, "push", "-q", "origin", "main")
+	gitCommand(t, "remote", "set-head", "origin", "main")
+
+	// main <- a <- b, and an empty branch that must not be mistaken for merged
+	gitCommand(t, "checkout", "-q", "-b", "a")
+	commitFile(t, "a.txt", "a")
+	commitFile(t, "a.txt", "a, amended")
+	if err := repo.CreateStackBranch("b"); err != nil {
+		t.Fatalf("CreateStackBranch() error = %v", err)
+	}
+	commitFile(t, "b.txt", "b")
+	gitCommand(t, "branch", "empty", "main")
+	gitCommand(t, "push", "-q", "origin", "a")
+
+	// Someone squash merges a on the remote
+	cloneDir := filepath.Join(t.TempDir(), "clone")
+	gitCommand(t, "clone", "-q", "--branch", "main", remoteDir, cloneDir)
+	for _, args := range [][]string{
+		{"merge", "-q", "--squash", "origin/a"},
+		{"-c", "user.name=Teammate", "-c", "user.email=teammate@example.com", "commit", "-q", "-m", "Squashed a"},
+		{"push", "-q", "origin", "main"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = cloneDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+
+	asked := []string{}
+	err := repo.SyncStacks(func(branchName string) bool {
+		asked = append(asked, branchName)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("SyncStacks() error = %v", err)
+	}
+
+	if len(asked) != 1 || asked[0] != "a" {
+		t.Errorf("asked to delete %v, want [a]", asked)
+	}
+	if _, err := repo.GetSha("refs/heads/a"); err == nil {
+		t.Error("a still exists after sync")
+	}
+	if _, err := repo.GetSha("refs/heads/empty"); err != nil {
+		t.Error("empty branch was deleted")
+	}
+
+	if got := repo.StackParent("b"); got != "main" {
+		t.Errorf("parent of b = %q, want main", got)
+	}
+	if got, want := gitCommand(t, "rev-parse", "main"), gitCommand(t, "rev-parse", "origin/main"); got != want {
+		t.Errorf("main = %s, want fast-forwarded to %s", got, want)
+	}
+	if got := gitCommand(t, "rev-list", "--count", "main..b"); got != "1" {
+		t.Errorf("b has %s commits on top of main, want 1", got)
+	}
+	if got := gitCommand(t, "rev-parse", "--abbrev-ref", "HEAD"); got != "b" {
+		t.Errorf("checked out branch = %s, want b", got)
+	}
+}